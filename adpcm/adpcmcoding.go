@@ -2,9 +2,19 @@ package adpcm
 
 import (
 	"fmt"
-	"os"
 )
 
+// NotEnoughSamplesError is returned when a loop starts too close
+// to the end of the samples for it to be encoded
+type NotEnoughSamplesError struct {
+	LoopStart   int
+	SampleCount int
+}
+
+func (err *NotEnoughSamplesError) Error() string {
+	return fmt.Sprintf("Not enough samples in file, the loop starts at %d and there are %d samples", err.LoopStart, err.SampleCount)
+}
+
 func innerProduct(length int, v1 []int32, v2 [16]int32) int32 {
 	var out int32 = 0
 	for i := 0; i < length; i = i + 1 {
@@ -430,11 +440,38 @@ func DecodeADPCM(data *ADPCMEncodedData) *PCMEncodedData {
 	return &result
 }
 
-func EncodeADPCM(data *PCMEncodedData, codebook *Codebook, loop *Loop, truncate bool, minLoopLength int) *ADPCMEncodedData {
+func EncodeADPCM(data *PCMEncodedData, codebook *Codebook, loop *Loop, truncate bool, minLoopLength int) (*ADPCMEncodedData, error) {
+	return EncodeADPCMWithEncoder(data, codebook, loop, truncate, minLoopLength, ENCODER_GREEDY)
+}
+
+// the 16 samples after pos or nil if there are none
+func nextFrameSamples(samples []int16, pos int) []int16 {
+	if pos+16 >= len(samples) {
+		return nil
+	}
+
+	var end = pos + 32
+
+	if end > len(samples) {
+		end = len(samples)
+	}
+
+	return samples[pos+16 : end]
+}
+
+func EncodeADPCMWithEncoder(data *PCMEncodedData, codebook *Codebook, loop *Loop, truncate bool, minLoopLength int, encoder Encoder) (*ADPCMEncodedData, error) {
 	var result []Frame = nil
 	var state []int32 = make([]int32, 16)
 	var currentPos = 0
 
+	var encode = func(input []int16, next []int16) *Frame {
+		if encoder == ENCODER_SEARCH {
+			return encodeFrameSearch(input, state, codebook, next)
+		}
+
+		return encodeFrame(input, state, codebook)
+	}
+
 	if loop != nil {
 		var nRepeats = 0
 		var newEnd = loop.End
@@ -445,11 +482,10 @@ func EncodeADPCM(data *PCMEncodedData, codebook *Codebook, loop *Loop, truncate
 
 		for currentPos <= loop.Start {
 			if currentPos+16 > len(data.Samples) {
-				fmt.Println("Not enough samples in file")
-				os.Exit(1)
+				return nil, &NotEnoughSamplesError{loop.Start, len(data.Samples)}
 			}
 
-			var frame = encodeFrame(data.Samples[currentPos:currentPos+16], state, codebook)
+			var frame = encode(data.Samples[currentPos:currentPos+16], nextFrameSamples(data.Samples, currentPos))
 			result = append(result, *frame)
 			currentPos += 16
 		}
@@ -468,7 +504,7 @@ func EncodeADPCM(data *PCMEncodedData, codebook *Codebook, loop *Loop, truncate
 		for nRepeats > 0 {
 			for ; currentPos+16 < loop.End; currentPos = currentPos + 16 {
 				if currentPos+16 <= len(data.Samples) {
-					var frame = encodeFrame(data.Samples[currentPos:currentPos+16], state, codebook)
+					var frame = encode(data.Samples[currentPos:currentPos+16], nextFrameSamples(data.Samples, currentPos))
 					result = append(result, *frame)
 				}
 			}
@@ -478,7 +514,7 @@ func EncodeADPCM(data *PCMEncodedData, codebook *Codebook, loop *Loop, truncate
 			var bufferStart = data.Samples[currentPos : currentPos+left]
 			var bufferLoop = data.Samples[loop.Start : loop.Start+16-left]
 
-			var frame = encodeFrame(append(bufferStart, bufferLoop...), state, codebook)
+			var frame = encode(append(bufferStart, bufferLoop...), nil)
 			result = append(result, *frame)
 			currentPos = loop.Start - left + 16
 			nRepeats = nRepeats - 1
@@ -506,7 +542,7 @@ func EncodeADPCM(data *PCMEncodedData, codebook *Codebook, loop *Loop, truncate
 			frames = append(frames, make([]int16, 16-sampleCount)...)
 		}
 
-		var frame = encodeFrame(frames, state, codebook)
+		var frame = encode(frames, nextFrameSamples(data.Samples[0:nFrames], currentPos))
 		result = append(result, *frame)
 
 		currentPos = currentPos + sampleCount
@@ -518,7 +554,7 @@ func EncodeADPCM(data *PCMEncodedData, codebook *Codebook, loop *Loop, truncate
 		codebook,
 		loop,
 		result,
-	}
+	}, nil
 }
 
 func EnocdeFrames(frames []Frame) []byte {
@@ -0,0 +1,164 @@
+package adpcm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+type Encoder int
+
+const (
+	// the greedy predictor and scale choice used by the sdk
+	ENCODER_GREEDY Encoder = iota
+	// searches every predictor and scale of each frame looking one frame ahead
+	ENCODER_SEARCH
+)
+
+func ParseEncoder(name string) (Encoder, error) {
+	if name == "greedy" || name == "" {
+		return ENCODER_GREEDY, nil
+	} else if name == "search" {
+		return ENCODER_SEARCH, nil
+	}
+
+	return ENCODER_GREEDY, errors.New(fmt.Sprintf("Invalid adpcm encoder '%s'. Expected greedy or search", name))
+}
+
+// the number of choices for a frame that are checked against the next frame
+const searchBeamWidth = 8
+const maxScale = 12
+
+type frameChoice struct {
+	predictor int32
+	scale     int32
+	ix        [16]int16
+	state     [16]int32
+	err       float64
+}
+
+// quantizes the frame with a fixed predictor and scale the same way the
+// decoder reconstructs it. Returns false if the decoded samples would
+// leave the 16 bit range so the decoder never has to clamp them
+func quantizeFrame(input *[16]int16, state []int32, codebook *Codebook, predictor int32, scale int32, result *frameChoice) bool {
+	var inVector [16]int32
+	var llevel int32 = -8
+	var ulevel int32 = 7
+
+	result.predictor = predictor
+	result.scale = scale
+	result.err = 0
+
+	for half := 0; half < 2; half = half + 1 {
+		for i := 0; i < codebook.Order; i = i + 1 {
+			if half == 0 {
+				inVector[i] = state[16-codebook.Order+i]
+			} else {
+				inVector[i] = result.state[8-codebook.Order+i]
+			}
+		}
+
+		for i := 0; i < 8; i = i + 1 {
+			var index = half*8 + i
+			var prediction = innerProduct(codebook.Order+i, codebook.Predictors[predictor].Table[i], inVector)
+			var ix = clip(int32(qsample(float32(input[index])-float32(prediction), 1<<scale)), llevel, ulevel)
+
+			inVector[codebook.Order+i] = ix * (1 << scale)
+
+			var decoded = prediction + inVector[codebook.Order+i]
+
+			if decoded < -0x8000 || decoded > 0x7fff {
+				return false
+			}
+
+			result.ix[index] = int16(ix)
+			result.state[index] = decoded
+
+			var difference = float64(input[index]) - float64(decoded)
+			result.err = result.err + difference*difference
+		}
+	}
+
+	return true
+}
+
+// every predictor and scale that can encode the frame sorted by error
+func frameChoices(input *[16]int16, state []int32, codebook *Codebook) []frameChoice {
+	var result []frameChoice = nil
+
+	for predictor := 0; predictor < len(codebook.Predictors); predictor = predictor + 1 {
+		for scale := int32(0); scale <= maxScale; scale = scale + 1 {
+			var choice frameChoice
+
+			if quantizeFrame(input, state, codebook, int32(predictor), scale, &choice) {
+				result = append(result, choice)
+			}
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].err < result[j].err
+	})
+
+	return result
+}
+
+func padFrame(input []int16) [16]int16 {
+	var result [16]int16
+
+	for i := 0; i < 16 && i < len(input); i = i + 1 {
+		result[i] = input[i]
+	}
+
+	return result
+}
+
+// encodeFrameSearch picks the predictor and scale of a frame that
+// minimizes the error of this frame plus the best error of the next
+// frame given the state this frame leaves behind. Falls back to the
+// greedy encoder if every choice would overflow
+func encodeFrameSearch(input []int16, state []int32, codebook *Codebook, next []int16) *Frame {
+	var inBuffer = padFrame(input)
+	var choices = frameChoices(&inBuffer, state, codebook)
+
+	if len(choices) == 0 {
+		return encodeFrame(input, state, codebook)
+	}
+
+	var best = 0
+
+	if next != nil {
+		var nextBuffer = padFrame(next)
+		var bestErr = math.Inf(1)
+
+		for index := 0; index < len(choices) && index < searchBeamWidth; index = index + 1 {
+			var total = choices[index].err
+			var nextChoices = frameChoices(&nextBuffer, choices[index].state[:], codebook)
+
+			if len(nextChoices) == 0 {
+				continue
+			}
+
+			total = total + nextChoices[0].err
+
+			if total < bestErr {
+				best = index
+				bestErr = total
+			}
+		}
+	}
+
+	var choice = &choices[best]
+	var result Frame
+
+	result.Header = uint8(choice.scale<<4) | uint8(choice.predictor&0xf)
+
+	for i := 0; i < 16; i = i + 2 {
+		result.Data[i/2] = uint8(choice.ix[i]<<4) | uint8(choice.ix[i+1]&0xf)
+	}
+
+	copy(state, choice.state[:])
+
+	return &result
+}
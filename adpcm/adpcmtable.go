@@ -503,6 +503,10 @@ type CompressionSettings struct {
 	Threshold   float64
 	Bits        int
 	RefineIters int
+	// when not nil the order and bits are picked per sound by SearchCodebook
+	Search *CodebookSearchSettings
+	// how the frames of a sound are encoded with the codebook
+	Encoder Encoder
 }
 
 func DefaultCompressionSettings() CompressionSettings {
@@ -512,6 +516,8 @@ func DefaultCompressionSettings() CompressionSettings {
 		10,
 		2,
 		2,
+		nil,
+		ENCODER_GREEDY,
 	}
 }
 
@@ -0,0 +1,159 @@
+package adpcm
+
+import (
+	"io"
+	"math"
+	"sort"
+)
+
+// the snr reported when the decoded audio matches the input exactly
+const MAX_SNR = 144
+
+type CodebookSearchSettings struct {
+	MaxOrder int
+	MaxBits  int
+	// the signal to noise ratio in decibels a codebook has to reach
+	TargetSNR float64
+	// where the codebook chosen for each sound is reported, nil to not report them
+	Report io.Writer
+}
+
+func DefaultCodebookSearchSettings() CodebookSearchSettings {
+	return CodebookSearchSettings{
+		4,
+		3,
+		30,
+		nil,
+	}
+}
+
+type CodebookSearchResult struct {
+	Order       int
+	Bits        int
+	SNR         float64
+	MetTarget   bool
+	Evaluations int
+}
+
+func (result *CodebookSearchResult) NPredictors() int {
+	return 1 << result.Bits
+}
+
+// the size of the codebook in bytes when stored in an instrument bank
+func (result *CodebookSearchResult) BookSize() int {
+	return 2 * PREDICTOR_SIZE * result.Order * result.NPredictors()
+}
+
+// MeasureSNR encodes and decodes the samples with the codebook and
+// returns the signal to noise ratio of the result in decibels
+func MeasureSNR(pcmData []int16, codebook *Codebook, encoder Encoder) (float64, error) {
+	if len(pcmData) == 0 {
+		return MAX_SNR, nil
+	}
+
+	encoded, err := EncodeADPCMWithEncoder(&PCMEncodedData{Samples: pcmData}, codebook, nil, false, 16, encoder)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var decoded = DecodeADPCM(encoded)
+
+	var signal float64 = 0
+	var noise float64 = 0
+
+	for i, sample := range pcmData {
+		var difference = float64(sample) - float64(decoded.Samples[i])
+		signal = signal + float64(sample)*float64(sample)
+		noise = noise + difference*difference
+	}
+
+	if noise == 0 {
+		return MAX_SNR, nil
+	} else if signal == 0 {
+		return -MAX_SNR, nil
+	}
+
+	return math.Min(10*math.Log10(signal/noise), MAX_SNR), nil
+}
+
+// SearchCodebook tries codebooks of increasing size up to the limits
+// in settings.Search and returns the smallest one that reaches the
+// target snr. If none of them do the codebook with the best snr is used
+func SearchCodebook(pcmData []int16, settings *CompressionSettings, encoder Encoder) (*Codebook, *CodebookSearchResult, error) {
+	type candidate struct {
+		order int
+		bits  int
+	}
+
+	var candidates []candidate = nil
+
+	for order := 1; order <= settings.Search.MaxOrder; order = order + 1 {
+		for bits := 0; bits <= settings.Search.MaxBits; bits = bits + 1 {
+			candidates = append(candidates, candidate{order, bits})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		var a = candidates[i].order << candidates[i].bits
+		var b = candidates[j].order << candidates[j].bits
+
+		if a != b {
+			return a < b
+		}
+
+		return candidates[i].order < candidates[j].order
+	})
+
+	var best *Codebook = nil
+	var bestResult *CodebookSearchResult = nil
+	var lastErr error = nil
+	var evaluations = 0
+
+	for _, next := range candidates {
+		var candidateSettings = *settings
+		candidateSettings.Order = next.order
+		candidateSettings.Bits = next.bits
+		candidateSettings.Search = nil
+
+		codebook, err := CalculateCodebook(pcmData, &candidateSettings)
+
+		// codebooks that overflow are skipped
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		snr, err := MeasureSNR(pcmData, codebook, encoder)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		evaluations = evaluations + 1
+
+		if bestResult == nil || snr > bestResult.SNR {
+			best = codebook
+			bestResult = &CodebookSearchResult{
+				Order:       next.order,
+				Bits:        next.bits,
+				SNR:         snr,
+				MetTarget:   false,
+				Evaluations: 0,
+			}
+		}
+
+		if snr >= settings.Search.TargetSNR {
+			bestResult.MetTarget = true
+			break
+		}
+	}
+
+	if best == nil {
+		return nil, nil, lastErr
+	}
+
+	bestResult.Evaluations = evaluations
+
+	return best, bestResult, nil
+}
@@ -0,0 +1,131 @@
+package aiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// IsPCM is true if the sound data is uncompressed integer or float samples
+func (aiff *Aiff) IsPCM() bool {
+	if !aiff.Compressed || aiff.Common == nil {
+		return true
+	}
+
+	switch aiff.Common.CompressionType {
+	case COMPRESSION_NONE, COMPRESSION_TWOS, COMPRESSION_SOWT,
+		COMPRESSION_FL32, COMPRESSION_FL32_UPPER, COMPRESSION_FL64, COMPRESSION_FL64_UPPER:
+		return true
+	}
+
+	return false
+}
+
+func (aiff *Aiff) isFloat() bool {
+	if !aiff.Compressed {
+		return false
+	}
+
+	switch aiff.Common.CompressionType {
+	case COMPRESSION_FL32, COMPRESSION_FL32_UPPER, COMPRESSION_FL64, COMPRESSION_FL64_UPPER:
+		return true
+	}
+
+	return false
+}
+
+// BitDepth is the number of meaningful bits in each sample
+func (aiff *Aiff) BitDepth() int {
+	if aiff.isFloat() {
+		return 32
+	}
+
+	return int(aiff.Common.SampleSize)
+}
+
+// ChannelSamples decodes the sample data into one array per channel
+// with each sample scaled to be in the range -1 to 1
+func (aiff *Aiff) ChannelSamples() ([][]float64, error) {
+	if aiff.Common == nil || aiff.SoundData == nil {
+		return nil, errors.New("aiff file is missing sound data")
+	}
+
+	if !aiff.IsPCM() {
+		return nil, errors.New("aiff file is compressed")
+	}
+
+	if aiff.Common.NumChannels <= 0 {
+		return nil, errors.New("aiff file has no channels")
+	}
+
+	var isFloat = aiff.isFloat()
+	var byteOrder binary.ByteOrder = binary.BigEndian
+	var sampleSize = int(aiff.Common.SampleSize+7) / 8
+
+	if aiff.Compressed {
+		switch aiff.Common.CompressionType {
+		case COMPRESSION_SOWT:
+			byteOrder = binary.LittleEndian
+		case COMPRESSION_FL32, COMPRESSION_FL32_UPPER:
+			sampleSize = 4
+		case COMPRESSION_FL64, COMPRESSION_FL64_UPPER:
+			sampleSize = 8
+		}
+	}
+
+	if !isFloat && (sampleSize < 1 || sampleSize > 4) {
+		return nil, errors.New(fmt.Sprintf("aiff file has unsupported sample size of %d bits", aiff.Common.SampleSize))
+	}
+
+	var channelCount = int(aiff.Common.NumChannels)
+	var frameSize = sampleSize * channelCount
+	var data = aiff.SoundData.WaveformData
+
+	if int(aiff.SoundData.Offset) < len(data) {
+		data = data[aiff.SoundData.Offset:]
+	}
+
+	var frameCount = len(data) / frameSize
+
+	if aiff.Common.NumSampleFrames >= 0 && int(aiff.Common.NumSampleFrames) < frameCount {
+		frameCount = int(aiff.Common.NumSampleFrames)
+	}
+
+	var result = make([][]float64, channelCount)
+
+	for channel := range result {
+		result[channel] = make([]float64, frameCount)
+	}
+
+	for frame := 0; frame < frameCount; frame = frame + 1 {
+		for channel := 0; channel < channelCount; channel = channel + 1 {
+			var offset = frame*frameSize + channel*sampleSize
+			var sampleData = data[offset : offset+sampleSize]
+
+			if isFloat {
+				if sampleSize == 4 {
+					result[channel][frame] = float64(math.Float32frombits(byteOrder.Uint32(sampleData)))
+				} else {
+					result[channel][frame] = math.Float64frombits(byteOrder.Uint64(sampleData))
+				}
+			} else {
+				var value int32 = 0
+
+				for index := 0; index < sampleSize; index = index + 1 {
+					var byteIndex = index
+
+					if byteOrder == binary.LittleEndian {
+						byteIndex = sampleSize - 1 - index
+					}
+
+					value = value | int32(sampleData[byteIndex])<<(8*(3-index))
+				}
+
+				result[channel][frame] = float64(value) / (1 << 31)
+			}
+		}
+	}
+
+	return result, nil
+}
@@ -13,6 +13,16 @@ const SSND = 0x53534E44
 const APPL = 0x4150504C
 const MARK = 0x4D41524B
 
+// aifc compression types
+const COMPRESSION_NONE = 0x4E4F4E45
+const COMPRESSION_TWOS = 0x74776F73
+const COMPRESSION_SOWT = 0x736F7774
+const COMPRESSION_FL32 = 0x666C3332
+const COMPRESSION_FL32_UPPER = 0x464C3332
+const COMPRESSION_FL64 = 0x666C3634
+const COMPRESSION_FL64_UPPER = 0x464C3634
+const COMPRESSION_VADPCM = 0x56415043
+
 // Sign * 1.Mantissa * pow(2, Exponent - 0x3FFF)
 type ExtendedFloat struct {
 	Sign     bool
@@ -0,0 +1,464 @@
+package al64
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/lambertjamesd/sfz2n64/midi"
+)
+
+// reads the bytes of a single track the same way the
+// compressed sequence player does, expanding any back references
+type alcseqTrackReader struct {
+	data        []byte
+	curLoc      int
+	backupPtr   int
+	backupLen   int
+	backupLoc   int
+	inBackup    bool
+	lastStatus  uint8
+	channel     uint8
+	currentTime uint32
+}
+
+func (reader *alcseqTrackReader) readRawByte() (uint8, error) {
+	if reader.curLoc >= len(reader.data) {
+		return 0, errors.New(fmt.Sprintf("Track for channel %d ended unexpectedly", reader.channel))
+	}
+
+	var result = reader.data[reader.curLoc]
+	reader.curLoc = reader.curLoc + 1
+	return result, nil
+}
+
+func (reader *alcseqTrackReader) readBackupByte() uint8 {
+	var result = reader.data[reader.backupPtr]
+	reader.backupPtr = reader.backupPtr + 1
+	reader.backupLen = reader.backupLen - 1
+
+	if reader.backupLen == 0 {
+		reader.curLoc = reader.backupLoc
+		reader.inBackup = false
+	}
+
+	return result
+}
+
+func (reader *alcseqTrackReader) readByte() (uint8, error) {
+	if reader.inBackup {
+		return reader.readBackupByte(), nil
+	}
+
+	result, err := reader.readRawByte()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if result != AL_CMIDI_BLOCK_CODE {
+		return result, nil
+	}
+
+	nextByte, err := reader.readRawByte()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if nextByte == AL_CMIDI_BLOCK_CODE {
+		return result, nil
+	}
+
+	loBackup, err := reader.readRawByte()
+
+	if err != nil {
+		return 0, err
+	}
+
+	length, err := reader.readRawByte()
+
+	if err != nil {
+		return 0, err
+	}
+
+	var backup = (int(nextByte) << 8) | int(loBackup)
+	var start = reader.curLoc - (backup + 4)
+
+	if start < 0 || length == 0 || start+int(length) > reader.curLoc {
+		return 0, errors.New(fmt.Sprintf("Invalid back reference in track for channel %d", reader.channel))
+	}
+
+	reader.backupPtr = start
+	reader.backupLen = int(length)
+	reader.backupLoc = reader.curLoc
+	reader.inBackup = true
+
+	return reader.readBackupByte(), nil
+}
+
+func (reader *alcseqTrackReader) readVarLen() (uint32, error) {
+	var result uint32 = 0
+
+	for {
+		curr, err := reader.readByte()
+
+		if err != nil {
+			return 0, err
+		}
+
+		result = (result << 7) | uint32(curr&0x7F)
+
+		if curr&0x80 == 0 {
+			return result, nil
+		}
+	}
+}
+
+func (reader *alcseqTrackReader) controlChange(controller uint8, value uint8) *midi.MidiEvent {
+	return &midi.MidiEvent{
+		AbsoluteTime: reader.currentTime,
+		EventType:    midi.ControlChange,
+		Channel:      reader.channel,
+		FirstParam:   controller,
+		SecondParam:  value,
+		Metadata:     nil,
+	}
+}
+
+func parseALCSeqTrack(data []byte, offset int, channel uint8) (*midi.Track, error) {
+	var reader = alcseqTrackReader{
+		data:    data,
+		curLoc:  offset,
+		channel: channel,
+	}
+
+	var events []*midi.MidiEvent = nil
+	// maps the location just after a loop start to its loop id
+	var loopStarts = make(map[int]uint8)
+
+	for {
+		delta, err := reader.readVarLen()
+
+		if err != nil {
+			return nil, err
+		}
+
+		reader.currentTime = reader.currentTime + delta
+
+		status, err := reader.readByte()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if status == 0xFF {
+			reader.lastStatus = 0
+
+			metaType, err := reader.readByte()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if metaType == midi.MetaTempo {
+				var tempo = make([]byte, 3)
+
+				for i := range tempo {
+					tempo[i], err = reader.readByte()
+
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				events = append(events, &midi.MidiEvent{
+					AbsoluteTime: reader.currentTime,
+					EventType:    midi.Metadata,
+					Channel:      0xF,
+					FirstParam:   midi.MetaTempo,
+					SecondParam:  0,
+					Metadata:     tempo,
+				})
+			} else if metaType == midi.MetaEnd {
+				events = append(events, &midi.MidiEvent{
+					AbsoluteTime: reader.currentTime,
+					EventType:    midi.Metadata,
+					Channel:      0xF,
+					FirstParam:   midi.MetaEnd,
+					SecondParam:  0,
+					Metadata:     nil,
+				})
+				break
+			} else if metaType == AL_CMIDI_LOOPSTART_CODE {
+				loopId, err := reader.readByte()
+
+				if err != nil {
+					return nil, err
+				}
+
+				_, err = reader.readByte()
+
+				if err != nil {
+					return nil, err
+				}
+
+				if reader.inBackup {
+					return nil, errors.New(fmt.Sprintf("Loop start in channel %d is inside of a back reference", channel))
+				}
+
+				loopStarts[reader.curLoc] = loopId
+				events = append(events, reader.controlChange(AL_CMIDI_CNTRL_LOOPSTART, loopId))
+			} else if metaType == AL_CMIDI_LOOPEND_CODE {
+				if reader.inBackup {
+					return nil, errors.New(fmt.Sprintf("Loop end in channel %d is inside of a back reference", channel))
+				}
+
+				var loopData = make([]byte, 6)
+
+				for i := range loopData {
+					loopData[i], err = reader.readRawByte()
+
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				var loopCount = loopData[0]
+				var loopOffset = int(binary.BigEndian.Uint32(loopData[2:]))
+
+				loopId, ok := loopStarts[reader.curLoc-loopOffset]
+
+				if !ok {
+					return nil, errors.New(fmt.Sprintf("Loop end in channel %d does not jump to a loop start", channel))
+				}
+
+				if loopCount < 128 {
+					events = append(events, reader.controlChange(AL_CMIDI_CNTRL_LOOPCOUNT_SM, loopCount))
+				} else if loopCount != AL_CMIDI_LOOP_INFINITE {
+					events = append(events, reader.controlChange(AL_CMIDI_CNTRL_LOOPCOUNT_BIG, loopCount-128))
+				}
+
+				events = append(events, reader.controlChange(AL_CMIDI_CNTRL_LOOPEND, loopId))
+			} else {
+				return nil, errors.New(fmt.Sprintf("Unknown meta event %X in channel %d", metaType, channel))
+			}
+
+			continue
+		}
+
+		var firstParam uint8
+
+		if status&0x80 != 0 {
+			reader.lastStatus = status
+
+			firstParam, err = reader.readByte()
+
+			if err != nil {
+				return nil, err
+			}
+		} else if reader.lastStatus == 0 {
+			return nil, errors.New(fmt.Sprintf("Running status with no previous status in channel %d", channel))
+		} else {
+			firstParam = status
+			status = reader.lastStatus
+		}
+
+		var eventType = midi.MidiEventType(status >> 4)
+
+		if eventType < midi.MidiOff || eventType >= midi.Metadata {
+			return nil, errors.New(fmt.Sprintf("Invalid status %X in channel %d", status, channel))
+		}
+
+		var event = &midi.MidiEvent{
+			AbsoluteTime: reader.currentTime,
+			EventType:    eventType,
+			Channel:      status & 0xF,
+			FirstParam:   firstParam,
+			SecondParam:  0,
+			Metadata:     nil,
+		}
+
+		if eventType != midi.ProgramChange && eventType != midi.ChannelAfterTouch {
+			event.SecondParam, err = reader.readByte()
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		events = append(events, event)
+
+		if eventType == midi.MidiOn {
+			duration, err := reader.readVarLen()
+
+			if err != nil {
+				return nil, err
+			}
+
+			events = append(events, &midi.MidiEvent{
+				AbsoluteTime: reader.currentTime + duration,
+				EventType:    midi.MidiOff,
+				Channel:      event.Channel,
+				FirstParam:   firstParam,
+				SecondParam:  0,
+				Metadata:     nil,
+			})
+		}
+	}
+
+	var endTime = reader.currentTime
+
+	for _, event := range events {
+		if event.AbsoluteTime > endTime {
+			endTime = event.AbsoluteTime
+		}
+	}
+
+	events[len(events)-1].AbsoluteTime = endTime
+
+	// note off events are sorted before other events at the same time
+	// so a note doesn't cut off the next note of the same pitch
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].AbsoluteTime == events[j].AbsoluteTime {
+			return events[i].EventType == midi.MidiOff && events[j].EventType != midi.MidiOff
+		}
+
+		return events[i].AbsoluteTime < events[j].AbsoluteTime
+	})
+
+	return &midi.Track{Events: events}, nil
+}
+
+func ParseALCSeq(input io.Reader) (*ALCSeq, error) {
+	data, err := ioutil.ReadAll(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < AL_CMIDI_HEADER_SIZE {
+		return nil, errors.New("Compact sequence is too small")
+	}
+
+	var result ALCSeq
+
+	var division = binary.BigEndian.Uint32(data[AL_CMIDI_CHANNEL_COUNT*4:])
+
+	if division == 0 || division > 0x7FFF {
+		return nil, errors.New(fmt.Sprintf("Invalid division in compact sequence %d", division))
+	}
+
+	result.Division = uint16(division)
+
+	for channel := 0; channel < AL_CMIDI_CHANNEL_COUNT; channel = channel + 1 {
+		var offset = int(binary.BigEndian.Uint32(data[channel*4:]))
+
+		if offset == 0 {
+			continue
+		}
+
+		if offset < AL_CMIDI_HEADER_SIZE || offset >= len(data) {
+			return nil, errors.New(fmt.Sprintf("Invalid track offset %d for channel %d", offset, channel))
+		}
+
+		track, err := parseALCSeqTrack(data, offset, uint8(channel))
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Channels[channel] = track
+	}
+
+	return &result, nil
+}
+
+// ALCSeqFromMidi splits the events of a midi file into a track for
+// each channel. Tempo events are placed in the first used channel
+func ALCSeqFromMidi(midiFile *midi.Midi) (*ALCSeq, error) {
+	if midiFile.IsSMPTE() {
+		return nil, errors.New("Sequence uses SMPTE time division which is not supported by the sequence player")
+	}
+
+	var result ALCSeq
+	result.Division = midiFile.TicksPerQuarter
+
+	var channelEvents [AL_CMIDI_CHANNEL_COUNT][]*midi.MidiEvent
+	var tempoEvents []*midi.MidiEvent = nil
+	var merged = midi.MergeTracks(midiFile).Tracks[0].Events
+	var endTime = merged[len(merged)-1].AbsoluteTime
+
+	for _, event := range merged {
+		if !isSupportedSeqEvent(event) {
+			continue
+		}
+
+		if event.EventType == midi.Metadata {
+			tempoEvents = append(tempoEvents, event)
+		} else {
+			channelEvents[event.Channel] = append(channelEvents[event.Channel], event)
+		}
+	}
+
+	var tempoChannel = 0
+
+	for tempoChannel < AL_CMIDI_CHANNEL_COUNT-1 && channelEvents[tempoChannel] == nil {
+		tempoChannel = tempoChannel + 1
+	}
+
+	if channelEvents[tempoChannel] == nil {
+		tempoChannel = 0
+	}
+
+	channelEvents[tempoChannel] = append(channelEvents[tempoChannel], tempoEvents...)
+
+	for channel, events := range channelEvents {
+		if events == nil {
+			continue
+		}
+
+		// the tempo events are added after the channel events so they
+		// need to be moved back into place, keeping them first at each tick
+		sort.SliceStable(events, func(i, j int) bool {
+			if events[i].AbsoluteTime == events[j].AbsoluteTime {
+				return events[i].EventType == midi.Metadata && events[j].EventType != midi.Metadata
+			}
+
+			return events[i].AbsoluteTime < events[j].AbsoluteTime
+		})
+
+		events = append(events, &midi.MidiEvent{
+			AbsoluteTime: endTime,
+			EventType:    midi.Metadata,
+			Channel:      0xF,
+			FirstParam:   midi.MetaEnd,
+			SecondParam:  0,
+			Metadata:     nil,
+		})
+
+		result.Channels[channel] = &midi.Track{Events: events}
+	}
+
+	return &result, nil
+}
+
+// ToMidi creates a type 1 midi file with a track for each used channel
+func (seq *ALCSeq) ToMidi() *midi.Midi {
+	var tracks []*midi.Track = nil
+
+	for _, track := range seq.Channels {
+		if track != nil {
+			tracks = append(tracks, track)
+		}
+	}
+
+	return &midi.Midi{
+		Type:            midi.MultipleTracks,
+		TicksPerQuarter: seq.Division,
+		Tracks:          tracks,
+	}
+}
@@ -0,0 +1,290 @@
+package al64
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lambertjamesd/sfz2n64/midi"
+)
+
+// back references take 4 bytes so shorter matches aren't worth it
+const alcseqMinBackupLength = 5
+const alcseqMaxBackupLength = 0xFF
+const alcseqMaxBackup = 0xFDFF
+const alcseqMaxCandidates = 256
+
+type alcseqTrackWriter struct {
+	output   []byte
+	copyable []bool
+	// maps the first 4 bytes of a sequence to positions in output that
+	// could be used as the source of a back reference
+	matches    map[uint32][]int
+	indexed    int
+	loopStarts map[uint8]int
+}
+
+func (writer *alcseqTrackWriter) updateMatches() {
+	for writer.indexed+4 <= len(writer.output) {
+		var start = writer.indexed
+		writer.indexed = writer.indexed + 1
+
+		if !writer.copyable[start] || !writer.copyable[start+1] || !writer.copyable[start+2] || !writer.copyable[start+3] {
+			continue
+		}
+
+		var key = binary.BigEndian.Uint32(writer.output[start:])
+		writer.matches[key] = append(writer.matches[key], start)
+	}
+}
+
+func (writer *alcseqTrackWriter) findMatch(data []byte) (int, int) {
+	if len(data) < alcseqMinBackupLength {
+		return 0, 0
+	}
+
+	var bestStart = 0
+	var bestLength = 0
+	var candidates = writer.matches[binary.BigEndian.Uint32(data)]
+	var checked = 0
+
+	for index := len(candidates) - 1; index >= 0 && checked < alcseqMaxCandidates; index = index - 1 {
+		var start = candidates[index]
+
+		if len(writer.output)-start > alcseqMaxBackup {
+			break
+		}
+
+		var length = 0
+
+		for length < len(data) &&
+			length < alcseqMaxBackupLength &&
+			start+length < len(writer.output) &&
+			writer.copyable[start+length] &&
+			writer.output[start+length] == data[length] {
+			length = length + 1
+		}
+
+		if length > bestLength {
+			bestStart = start
+			bestLength = length
+		}
+
+		checked = checked + 1
+	}
+
+	return bestStart, bestLength
+}
+
+func (writer *alcseqTrackWriter) writeRaw(data []byte) {
+	for _, value := range data {
+		writer.output = append(writer.output, value)
+		writer.copyable = append(writer.copyable, false)
+	}
+}
+
+// writes data using back references to any previously written
+// data that can be read by the sequence player as is
+func (writer *alcseqTrackWriter) writeCompressed(data []byte) {
+	var index = 0
+
+	for index < len(data) {
+		writer.updateMatches()
+
+		start, length := writer.findMatch(data[index:])
+
+		if length >= alcseqMinBackupLength {
+			var backup = len(writer.output) - start
+			writer.writeRaw([]byte{AL_CMIDI_BLOCK_CODE, uint8(backup >> 8), uint8(backup), uint8(length)})
+			index = index + length
+		} else if data[index] == AL_CMIDI_BLOCK_CODE {
+			writer.writeRaw([]byte{AL_CMIDI_BLOCK_CODE, AL_CMIDI_BLOCK_CODE})
+			index = index + 1
+		} else {
+			writer.output = append(writer.output, data[index])
+			writer.copyable = append(writer.copyable, true)
+			index = index + 1
+		}
+	}
+}
+
+func appendVarInt(data []byte, value uint32) []byte {
+	var bytes = []byte{uint8(value & 0x7F)}
+	value = value >> 7
+
+	for value != 0 {
+		bytes = append([]byte{uint8(value&0x7F) | 0x80}, bytes...)
+		value = value >> 7
+	}
+
+	return append(data, bytes...)
+}
+
+func calculateNoteDurations(events []*midi.MidiEvent) map[*midi.MidiEvent]uint32 {
+	var result = make(map[*midi.MidiEvent]uint32)
+	var activeNotes = make(map[uint8][]*midi.MidiEvent)
+	var endTime uint32 = 0
+
+	for _, event := range events {
+		if event.AbsoluteTime > endTime {
+			endTime = event.AbsoluteTime
+		}
+
+		if midi.IsNoteOff(event) {
+			var active = activeNotes[event.FirstParam]
+
+			if len(active) > 0 {
+				result[active[0]] = event.AbsoluteTime - active[0].AbsoluteTime
+				activeNotes[event.FirstParam] = active[1:]
+			}
+		} else if event.EventType == midi.MidiOn {
+			activeNotes[event.FirstParam] = append(activeNotes[event.FirstParam], event)
+		}
+	}
+
+	for _, active := range activeNotes {
+		for _, event := range active {
+			result[event] = endTime - event.AbsoluteTime
+		}
+	}
+
+	return result
+}
+
+func serializeALCSeqTrack(track *midi.Track, channel int) ([]byte, error) {
+	var writer = alcseqTrackWriter{
+		matches:    make(map[uint32][]int),
+		loopStarts: make(map[uint8]int),
+	}
+
+	var durations = calculateNoteDurations(track.Events)
+	var pending []byte = nil
+	var lastTime uint32 = 0
+	var lastStatus uint8 = 0
+	var loopCount uint8 = AL_CMIDI_LOOP_INFINITE
+	var hasEnd = false
+
+	for _, event := range track.Events {
+		if midi.IsNoteOff(event) {
+			continue
+		}
+
+		if event.EventType == midi.ControlChange {
+			if event.FirstParam == AL_CMIDI_CNTRL_LOOPCOUNT_SM {
+				loopCount = event.SecondParam
+				continue
+			} else if event.FirstParam == AL_CMIDI_CNTRL_LOOPCOUNT_BIG {
+				if event.SecondParam >= 127 {
+					loopCount = AL_CMIDI_LOOP_INFINITE - 1
+				} else {
+					loopCount = event.SecondParam + 128
+				}
+				continue
+			}
+		}
+
+		pending = appendVarInt(pending, event.AbsoluteTime-lastTime)
+		lastTime = event.AbsoluteTime
+
+		if event.EventType == midi.ControlChange && event.FirstParam == AL_CMIDI_CNTRL_LOOPSTART {
+			writer.writeCompressed(pending)
+			pending = nil
+			writer.writeRaw([]byte{0xFF, AL_CMIDI_LOOPSTART_CODE, event.SecondParam, 0xFF})
+			writer.loopStarts[event.SecondParam] = len(writer.output)
+			lastStatus = 0
+		} else if event.EventType == midi.ControlChange && event.FirstParam == AL_CMIDI_CNTRL_LOOPEND {
+			loopStart, ok := writer.loopStarts[event.SecondParam]
+
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Loop end for loop %d in channel %d has no loop start", event.SecondParam, channel))
+			}
+
+			writer.writeCompressed(pending)
+			pending = nil
+			writer.writeRaw([]byte{0xFF, AL_CMIDI_LOOPEND_CODE, loopCount, loopCount})
+
+			var offset = make([]byte, 4)
+			binary.BigEndian.PutUint32(offset, uint32(len(writer.output)+4-loopStart))
+			writer.writeRaw(offset)
+
+			loopCount = AL_CMIDI_LOOP_INFINITE
+			lastStatus = 0
+		} else if event.EventType == midi.Metadata {
+			if event.FirstParam == midi.MetaTempo {
+				if len(event.Metadata) != 3 {
+					return nil, errors.New(fmt.Sprintf("Tempo event in channel %d should have 3 bytes of data", channel))
+				}
+
+				pending = append(pending, 0xFF, midi.MetaTempo)
+				pending = append(pending, event.Metadata...)
+			} else if event.FirstParam == midi.MetaEnd {
+				pending = append(pending, 0xFF, midi.MetaEnd)
+				hasEnd = true
+				break
+			} else {
+				return nil, errors.New(fmt.Sprintf("Meta event %X in channel %d is not supported by compact sequences", event.FirstParam, channel))
+			}
+
+			lastStatus = 0
+		} else {
+			var status = (uint8(event.EventType) << 4) | uint8(channel)
+
+			if status != lastStatus {
+				pending = append(pending, status)
+				lastStatus = status
+			}
+
+			pending = append(pending, event.FirstParam)
+
+			if event.EventType != midi.ProgramChange && event.EventType != midi.ChannelAfterTouch {
+				pending = append(pending, event.SecondParam)
+			}
+
+			if event.EventType == midi.MidiOn {
+				pending = appendVarInt(pending, durations[event])
+			}
+		}
+	}
+
+	if !hasEnd {
+		pending = appendVarInt(pending, 0)
+		pending = append(pending, 0xFF, midi.MetaEnd)
+	}
+
+	writer.writeCompressed(pending)
+
+	return writer.output, nil
+}
+
+func (seq *ALCSeq) Serialize(target io.Writer) error {
+	var header [AL_CMIDI_CHANNEL_COUNT + 1]uint32
+	var data []byte = nil
+
+	for channel, track := range seq.Channels {
+		if track == nil {
+			continue
+		}
+
+		trackData, err := serializeALCSeqTrack(track, channel)
+
+		if err != nil {
+			return err
+		}
+
+		header[channel] = uint32(AL_CMIDI_HEADER_SIZE + len(data))
+		data = append(data, trackData...)
+	}
+
+	header[AL_CMIDI_CHANNEL_COUNT] = uint32(seq.Division)
+
+	err := binary.Write(target, binary.BigEndian, &header)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = target.Write(data)
+
+	return err
+}
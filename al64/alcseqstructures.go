@@ -0,0 +1,34 @@
+package al64
+
+import "github.com/lambertjamesd/sfz2n64/midi"
+
+const AL_CMIDI_CHANNEL_COUNT = 16
+
+// size of the 16 track offsets and the division at the start of a compact sequence
+const AL_CMIDI_HEADER_SIZE = AL_CMIDI_CHANNEL_COUNT*4 + 4
+
+const (
+	AL_CMIDI_BLOCK_CODE     = 0xFE
+	AL_CMIDI_LOOPSTART_CODE = 0x2E
+	AL_CMIDI_LOOPEND_CODE   = 0x2D
+)
+
+// controllers used in standard midi files to mark loops
+// they are converted to loop events in the compact format
+const (
+	AL_CMIDI_CNTRL_LOOPSTART     = 102
+	AL_CMIDI_CNTRL_LOOPEND       = 103
+	AL_CMIDI_CNTRL_LOOPCOUNT_SM  = 104
+	AL_CMIDI_CNTRL_LOOPCOUNT_BIG = 105
+)
+
+const AL_CMIDI_LOOP_INFINITE = 0xFF
+
+// ALCSeq is the sequence format used by the libultra compressed sequence
+// player. Each midi channel is stored in its own track. The tracks hold
+// regular midi events with note off events and loop controllers that are
+// converted when the sequence is serialized. Channels that aren't used are nil
+type ALCSeq struct {
+	Division uint16
+	Channels [AL_CMIDI_CHANNEL_COUNT]*midi.Track
+}
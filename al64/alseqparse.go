@@ -1,7 +1,234 @@
 package al64
 
-import "io"
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 
-func ParseALSeq(input io.Writer) (*ALBank, error) {
-	return nil, nil
+	"github.com/lambertjamesd/sfz2n64/midi"
+)
+
+func isSupportedSeqEvent(event *midi.MidiEvent) bool {
+	if event.EventType == midi.Metadata {
+		return event.IsMeta() && event.FirstParam == midi.MetaTempo
+	}
+
+	return event.EventType >= midi.MidiOff && event.EventType < midi.Metadata
+}
+
+func ParseALSeq(input io.Reader) (*ALSeq, error) {
+	data, err := ioutil.ReadAll(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	midiFile, err := midi.ReadMidi(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if midiFile.Type != midi.SingleTrack || len(midiFile.Tracks) != 1 {
+		return nil, errors.New(fmt.Sprintf("Sequence should be a type 0 midi file with a single track, got type %d with %d tracks", midiFile.Type, len(midiFile.Tracks)))
+	}
+
+	if midiFile.IsSMPTE() {
+		return nil, errors.New("Sequence uses SMPTE time division which is not supported by the sequence player")
+	}
+
+	return &ALSeq{
+		midiFile.TicksPerQuarter,
+		midiFile.Tracks[0].Events,
+	}, nil
+}
+
+// ALSeqFromMidi flattens all tracks of a midi file into a single track and
+// removes any events the sequence player cannot use. Control change events,
+// including the loop controllers, are passed through unmodified
+func ALSeqFromMidi(midiFile *midi.Midi) (*ALSeq, error) {
+	if midiFile.IsSMPTE() {
+		return nil, errors.New("Sequence uses SMPTE time division which is not supported by the sequence player")
+	}
+
+	var events []*midi.MidiEvent = nil
+	var merged = midi.MergeTracks(midiFile).Tracks[0].Events
+
+	for _, event := range merged {
+		if isSupportedSeqEvent(event) || event == merged[len(merged)-1] {
+			events = append(events, event)
+		}
+	}
+
+	return &ALSeq{
+		midiFile.TicksPerQuarter,
+		events,
+	}, nil
+}
+
+func (seq *ALSeq) ToMidi() *midi.Midi {
+	return &midi.Midi{
+		Type:            midi.SingleTrack,
+		TicksPerQuarter: seq.Division,
+		Tracks:          []*midi.Track{&midi.Track{Events: seq.Events}},
+	}
+}
+
+const maxSeqCount = 4096
+
+func ReadSeqFile(source SeekableReader) (*ALSeqFile, error) {
+	fileSize, err := source.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = source.Seek(0, io.SeekStart)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var revision int16
+	err = binary.Read(source, binary.BigEndian, &revision)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if revision != SEQ_REVISION {
+		return nil, errors.New("Bad revision number")
+	}
+
+	var seqCount int16
+	err = binary.Read(source, binary.BigEndian, &seqCount)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if seqCount < 0 || seqCount > maxSeqCount {
+		return nil, errors.New(fmt.Sprintf("Invalid value for seqCount %d", seqCount))
+	}
+
+	var result ALSeqFile
+	result.SeqArray = make([]*ALSeqData, seqCount)
+
+	var offsets = make([]int32, seqCount)
+	var lengths = make([]int32, seqCount)
+
+	for i := 0; int16(i) < seqCount; i = i + 1 {
+		err = binary.Read(source, binary.BigEndian, &offsets[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = binary.Read(source, binary.BigEndian, &lengths[i])
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// sequences that share the same data share the same ALSeqData
+	var parsed = make(map[int32]*ALSeqData)
+
+	for i := range result.SeqArray {
+		var offset = offsets[i]
+		var length = lengths[i]
+
+		if offset < 0 || length < 0 || int64(offset)+int64(length) > fileSize {
+			return nil, errors.New(fmt.Sprintf("Sequence %d at offset %d with length %d is outside of the file", i, offset, length))
+		}
+
+		existing, ok := parsed[offset]
+
+		if ok && len(existing.Data) == int(length) {
+			result.SeqArray[i] = existing
+			continue
+		}
+
+		_, err = source.Seek(int64(offset), io.SeekStart)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var seqData = &ALSeqData{make([]byte, length)}
+
+		_, err = io.ReadFull(source, seqData.Data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		parsed[offset] = seqData
+		result.SeqArray[i] = seqData
+	}
+
+	return &result, nil
+}
+
+func (seqData *ALSeqData) IsCompact() bool {
+	return len(seqData.Data) < 4 || binary.BigEndian.Uint32(seqData.Data) != midi.MidiHeader
+}
+
+// ToMidi decodes the sequence data whether it is a midi
+// file or a compact sequence
+func (seqData *ALSeqData) ToMidi() (*midi.Midi, error) {
+	if seqData.IsCompact() {
+		seq, err := ParseALCSeq(bytes.NewReader(seqData.Data))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return seq.ToMidi(), nil
+	}
+
+	seq, err := ParseALSeq(bytes.NewReader(seqData.Data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return seq.ToMidi(), nil
+}
+
+// ALSeqDataFromMidi converts a midi file into the sequence data
+// used by either the compact or the regular sequence player
+func ALSeqDataFromMidi(midiFile *midi.Midi, compact bool) (*ALSeqData, error) {
+	var data bytes.Buffer
+
+	if compact {
+		seq, err := ALCSeqFromMidi(midiFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = seq.Serialize(&data)
+
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		seq, err := ALSeqFromMidi(midiFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = seq.Serialize(&data)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ALSeqData{data.Bytes()}, nil
 }
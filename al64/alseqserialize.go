@@ -0,0 +1,90 @@
+package al64
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/lambertjamesd/sfz2n64/midi"
+)
+
+func (seq *ALSeq) Serialize(target io.Writer) error {
+	return midi.WriteMidi(target, seq.ToMidi())
+}
+
+// ALSeqData
+func (seqData *ALSeqData) serializeWrite(state *alSerializeState, target io.Writer) error {
+	_, err := target.Write(seqData.Data)
+	return err
+}
+
+func (seqData *ALSeqData) sizeInBytes() int {
+	return len(seqData.Data)
+}
+
+func (seqData *ALSeqData) byteAlign() int {
+	return 8
+}
+
+func (seqData *ALSeqData) generateLayout(state *alSerializeState) {
+
+}
+
+// ALSeqFile
+func (seqFile *ALSeqFile) serializeWrite(state *alSerializeState, target io.Writer) error {
+	var revision int16 = SEQ_REVISION
+	err := binary.Write(target, binary.BigEndian, &revision)
+
+	if err != nil {
+		return err
+	}
+
+	var seqCount int16 = int16(len(seqFile.SeqArray))
+	err = binary.Write(target, binary.BigEndian, &seqCount)
+
+	if err != nil {
+		return err
+	}
+
+	for _, seqData := range seqFile.SeqArray {
+		var offset = state.getSerializableOffset(seqData)
+		err = binary.Write(target, binary.BigEndian, &offset)
+
+		if err != nil {
+			return err
+		}
+
+		var length = int32(len(seqData.Data))
+		err = binary.Write(target, binary.BigEndian, &length)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (seqFile *ALSeqFile) sizeInBytes() int {
+	return 4 + 8*len(seqFile.SeqArray)
+}
+
+func (seqFile *ALSeqFile) byteAlign() int {
+	return 8
+}
+
+func (seqFile *ALSeqFile) generateLayout(state *alSerializeState) {
+	for _, seqData := range seqFile.SeqArray {
+		state.layoutSerializable(seqData)
+	}
+}
+
+func (seqFile *ALSeqFile) Serialize(target io.Writer) error {
+	var state alSerializeState = alSerializeState{
+		make(map[alSerializable]int),
+		nil,
+		0,
+	}
+
+	state.layoutSerializable(seqFile)
+	return state.writeOut(target)
+}
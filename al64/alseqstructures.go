@@ -0,0 +1,23 @@
+package al64
+
+import "github.com/lambertjamesd/sfz2n64/midi"
+
+// ALSeq is the sequence format used by the libultra sequence player. The
+// data is laid out as a type 0 midi file so the events are stored as midi
+// events in a single track
+type ALSeq struct {
+	Division uint16
+	Events   []*midi.MidiEvent
+}
+
+const SEQ_REVISION = 0x5331
+
+// ALSeqData holds the bytes of a single sequence in a sequence bank. The
+// data is either a type 0 midi file or a compact sequence
+type ALSeqData struct {
+	Data []byte
+}
+
+type ALSeqFile struct {
+	SeqArray []*ALSeqData
+}
@@ -519,23 +519,38 @@ func (sound *ALSound) LayoutTbl(tblData []byte) []byte {
 	return tblData
 }
 
-func (instrument *ALInstrument) LayoutTbl(tblData []byte) []byte {
+// wavetables shared between sounds are only added once
+func (instrument *ALInstrument) layoutTbl(tblData []byte, added map[*ALWavetable]bool) []byte {
 	if instrument == nil {
 		return tblData
 	}
 
 	for _, sound := range instrument.SoundArray {
+		if sound.Wavetable != nil && added[sound.Wavetable] {
+			continue
+		}
+
 		tblData = sound.LayoutTbl(tblData)
+
+		if sound.Wavetable != nil {
+			added[sound.Wavetable] = true
+		}
 	}
 
 	return tblData
 }
 
+func (instrument *ALInstrument) LayoutTbl(tblData []byte) []byte {
+	return instrument.layoutTbl(tblData, make(map[*ALWavetable]bool))
+}
+
 func (bankFile *ALBankFile) LayoutTbl(tblData []byte) []byte {
+	var added = make(map[*ALWavetable]bool)
+
 	for _, bank := range bankFile.BankArray {
-		tblData = bank.Percussion.LayoutTbl(tblData)
+		tblData = bank.Percussion.layoutTbl(tblData, added)
 		for _, ins := range bank.InstArray {
-			tblData = ins.LayoutTbl(tblData)
+			tblData = ins.layoutTbl(tblData, added)
 		}
 	}
 
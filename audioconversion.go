@@ -11,8 +11,8 @@ import (
 	"github.com/lambertjamesd/sfz2n64/audioconvert"
 )
 
-func convertAudio(input string, output string, compressionSettings *adpcm.CompressionSettings) {
-	sound, err := audioconvert.ReadWavetable(input)
+func convertAudio(input string, output string, compressionSettings *adpcm.CompressionSettings, pcmSettings *audioconvert.PCMConversionSettings) {
+	sound, err := audioconvert.ReadWavetable(input, pcmSettings)
 
 	if err != nil {
 		fmt.Println(err)
@@ -24,9 +24,10 @@ func convertAudio(input string, output string, compressionSettings *adpcm.Compre
 	if outExt == ".table" {
 		var codebook *adpcm.Codebook = nil
 		if sound.Wavetable.Type == al64.AL_RAW16_WAVE {
-			codebook, err = adpcm.CalculateCodebook(
+			codebook, err = audioconvert.CalculateCodebook(
 				audioconvert.DecodeSamples(sound.Wavetable.DataFromTable, binary.BigEndian),
 				compressionSettings,
+				filepath.Base(input),
 			)
 
 			if err != nil {
@@ -70,7 +71,7 @@ func convertAudio(input string, output string, compressionSettings *adpcm.Compre
 			os.Exit(1)
 		}
 	} else if outExt == ".wav" {
-		err = audioconvert.WriteWav(output, sound.Wavetable, sound.Wavetable.DataFromTable, sound.Wavetable.FileSampleRate)
+		err = audioconvert.WriteWav(output, sound.Wavetable, sound.Wavetable.DataFromTable, sound.Wavetable.FileSampleRate, sound.KeyMap)
 	} else {
 		fmt.Printf("Could not convert %s to %s\n", input, output)
 	}
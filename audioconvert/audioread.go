@@ -6,15 +6,114 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/lambertjamesd/sfz2n64/aiff"
 	"github.com/lambertjamesd/sfz2n64/al64"
 	"github.com/lambertjamesd/sfz2n64/wav"
 )
 
-func wavToSoundEntry(filename string) (*al64.ALSound, error) {
+// uses the unity note and fine tune of the smpl chunk
+func wavKeyMap(waveFile *wav.Wave) *al64.ALKeyMap {
+	if waveFile.Sampler == nil {
+		return nil
+	}
+
+	var unityNote = waveFile.Sampler.MIDIUnityNote
+
+	if unityNote > 127 {
+		unityNote = 127
+	}
+
+	var cents = int(math.Round(float64(waveFile.Sampler.MIDIPitchFraction) * 100 / (1 << 32)))
+
+	var result = &al64.ALKeyMap{
+		VelocityMin: 0,
+		VelocityMax: 127,
+		KeyMin:      0,
+		KeyMax:      127,
+		KeyBase:     uint8(unityNote),
+		Detune:      uint8(-cents),
+	}
+
+	// the sample is tuned up from the unity note so the
+	// detune lowers the pitch to match
+	if cents > 50 && unityNote < 127 {
+		result.KeyBase = uint8(unityNote + 1)
+		result.Detune = uint8(100 - cents)
+	}
+
+	return result
+}
+
+func isCueLabel(label string, names ...string) bool {
+	label = strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(label, " ", ""), "_", ""))
+
+	for _, name := range names {
+		if label == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// uses the first loop of the smpl chunk or cue points labeled start and end
+func wavLoop(waveFile *wav.Wave, sampleCount uint32) *al64.ALRawLoop {
+	var start uint32 = 0
+	var end uint32 = 0
+	var count uint32 = 0xffffffff
+	var hasLoop = false
+
+	if waveFile.Sampler != nil && len(waveFile.Sampler.Loops) > 0 {
+		var loop = waveFile.Sampler.Loops[0]
+
+		start = loop.Start
+		// the end of the loop is the last sample played
+		end = loop.End + 1
+		hasLoop = true
+
+		if loop.PlayCount != 0 {
+			count = loop.PlayCount
+		}
+	} else {
+		var hasStart = false
+		var hasEnd = false
+
+		for _, cue := range waveFile.Cues {
+			var label = waveFile.FindLabel(cue.ID)
+
+			if isCueLabel(label, "start", "loopstart") {
+				start = cue.SampleOffset
+				hasStart = true
+			} else if isCueLabel(label, "end", "loopend") {
+				end = cue.SampleOffset
+				hasEnd = true
+			}
+		}
+
+		hasLoop = hasStart && hasEnd
+	}
+
+	if end > sampleCount {
+		end = sampleCount
+	}
+
+	if !hasLoop || start >= end {
+		return nil
+	}
+
+	return &al64.ALRawLoop{
+		Start: start,
+		End:   end,
+		Count: count,
+	}
+}
+
+func wavToSoundEntry(filename string, settings *PCMConversionSettings) (*al64.ALSound, error) {
 	file, err := os.Open(filename)
 
 	if err != nil {
@@ -29,19 +128,19 @@ func wavToSoundEntry(filename string) (*al64.ALSound, error) {
 		return nil, err
 	}
 
-	if waveFile.Header.Format != wav.FORMAT_PCM {
-		return nil, errors.New(fmt.Sprintf("%s should be pcm", filename))
-	}
+	channels, err := waveFile.ChannelSamples()
 
-	if waveFile.Header.NChannels != 1 {
-		return nil, errors.New(fmt.Sprintf("%s should have 1 channel", filename))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error reading samples: %s error: %s", filename, err.Error()))
 	}
 
-	if waveFile.Header.BitsPerSample != 16 {
-		return nil, errors.New(fmt.Sprintf("%s should have 16 bits per sample", filename))
+	samples, err := ConvertToPCM16(channels, waveFile.Header.BitDepth(), settings)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error reading samples: %s error: %s", filename, err.Error()))
 	}
 
-	SwapEndian(waveFile.Data)
+	waveFile.Data = EncodeSamples(samples, binary.BigEndian)
 
 	var result al64.ALSound
 
@@ -65,6 +164,8 @@ func wavToSoundEntry(filename string) (*al64.ALSound, error) {
 
 	result.Wavetable.DataFromTable = waveFile.Data
 	result.Wavetable.FileSampleRate = waveFile.Header.SampleRate
+	result.Wavetable.RawWave.Loop = wavLoop(waveFile, uint32(len(waveFile.Data)/2))
+	result.KeyMap = wavKeyMap(waveFile)
 
 	result.SamplePan = 64
 	result.SampleVolume = 127
@@ -72,7 +173,7 @@ func wavToSoundEntry(filename string) (*al64.ALSound, error) {
 	return &result, nil
 }
 
-func aiffToSoundEntry(filename string) (*al64.ALSound, error) {
+func aiffToSoundEntry(filename string, settings *PCMConversionSettings) (*al64.ALSound, error) {
 	file, err := os.Open(filename)
 
 	if err != nil {
@@ -87,19 +188,19 @@ func aiffToSoundEntry(filename string) (*al64.ALSound, error) {
 		return nil, errors.New(fmt.Sprintf("Error parsing file: %s error: %s", filename, err.Error()))
 	}
 
-	if aiffFile.Common.NumChannels != 1 {
-		return nil, errors.New(fmt.Sprintf("%s should have 1 channel", filename))
-	}
-
-	if aiffFile.Common.SampleSize != 16 {
-		return nil, errors.New(fmt.Sprintf("%s should have 16 bits per sample", filename))
+	if aiffFile.Common == nil || aiffFile.SoundData == nil {
+		return nil, errors.New(fmt.Sprintf("%s is missing sound data", filename))
 	}
 
 	var result al64.ALSound
 
 	var sampleRate = uint32(aiff.F64FromExtended(aiffFile.Common.SampleRate))
 
-	if aiffFile.Compressed {
+	if !aiffFile.IsPCM() {
+		if aiffFile.Common.NumChannels != 1 {
+			return nil, errors.New(fmt.Sprintf("%s should have 1 channel", filename))
+		}
+
 		result.Wavetable = &al64.ALWavetable{
 			Base:           0,
 			Len:            int32(len(aiffFile.SoundData.WaveformData)),
@@ -171,6 +272,20 @@ func aiffToSoundEntry(filename string) (*al64.ALSound, error) {
 			return nil, errors.New("Could not find book in wavetable")
 		}
 	} else {
+		channels, err := aiffFile.ChannelSamples()
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error reading samples: %s error: %s", filename, err.Error()))
+		}
+
+		samples, err := ConvertToPCM16(channels, aiffFile.BitDepth(), settings)
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error reading samples: %s error: %s", filename, err.Error()))
+		}
+
+		aiffFile.SoundData.WaveformData = EncodeSamples(samples, binary.BigEndian)
+
 		result.Wavetable = &al64.ALWavetable{
 			Base:           0,
 			Len:            int32(len(aiffFile.SoundData.WaveformData)),
@@ -220,7 +335,7 @@ func aiffToSoundEntry(filename string) (*al64.ALSound, error) {
 	return &result, nil
 }
 
-func insToSoundEntry(filename string) (*al64.ALSound, error) {
+func insToSoundEntry(filename string, settings *PCMConversionSettings) (*al64.ALSound, error) {
 	file, err := ioutil.ReadFile(filename)
 
 	if err != nil {
@@ -228,7 +343,7 @@ func insToSoundEntry(filename string) (*al64.ALSound, error) {
 	}
 
 	instFile, parseErrors := al64.ParseIns(string(file), filename, func(waveFilename string) (*al64.ALWavetable, error) {
-		sound, err := ReadWavetable(waveFilename)
+		sound, err := ReadWavetable(waveFilename, settings)
 
 		if err != nil {
 			return nil, err
@@ -266,63 +381,56 @@ func insToSoundEntry(filename string) (*al64.ALSound, error) {
 	return asSound, nil
 }
 
-func readWavetable(filename string) (*al64.ALSound, error) {
+func readWavetable(filename string, settings *PCMConversionSettings) (*al64.ALSound, error) {
 	var ext = filepath.Ext(filename)
 
 	if ext == ".wav" {
-		return wavToSoundEntry(filename)
+		return wavToSoundEntry(filename, settings)
 	} else if ext == ".aiff" || ext == ".aifc" || ext == ".aif" {
-		return aiffToSoundEntry(filename)
+		return aiffToSoundEntry(filename, settings)
 	} else if ext == ".ins" {
-		return insToSoundEntry(filename)
+		return insToSoundEntry(filename, settings)
 	} else {
 		return nil, errors.New("Not a supported sound file " + filename)
 	}
 }
 
-var wavetableCache = make(map[string]*al64.ALSound)
+// the same file read with different settings gives a different sound
+type wavetableCacheKey struct {
+	filename string
+	settings PCMConversionSettings
+}
+
+var wavetableCache = make(map[wavetableCacheKey]*al64.ALSound)
 
-func ReadWavetable(filename string) (*al64.ALSound, error) {
-	cached, has := wavetableCache[filename]
+// ReadWavetable reads a sound from an audio or ins file. settings decides
+// how multichannel and high bit depth audio is converted, nil uses the defaults
+func ReadWavetable(filename string, settings *PCMConversionSettings) (*al64.ALSound, error) {
+	if settings == nil {
+		var defaultSettings = DefaultPCMConversionSettings()
+		settings = &defaultSettings
+	}
+
+	var key = wavetableCacheKey{filename, *settings}
+	cached, has := wavetableCache[key]
 
 	if has {
 		return cached, nil
 	}
 
-	result, err := readWavetable(filename)
+	result, err := readWavetable(filename, settings)
 
 	if err != nil {
 		return nil, err
 	}
 
-	wavetableCache[filename] = result
+	wavetableCache[key] = result
 
 	return result, nil
 }
 
-func buildTblInstrument(instrument *al64.ALInstrument, result []byte) []byte {
-	for _, sound := range instrument.SoundArray {
-		sound.Wavetable.Base = int32(len(result))
-		result = append(result, sound.Wavetable.DataFromTable...)
-	}
-
-	return result
-}
-
+// BuildTbl uses the same layout as ALBankFile.LayoutTbl so the
+// tbl file doesn't change depending on how the bank was converted
 func BuildTbl(banks *al64.ALBankFile) []byte {
-	var result []byte = nil
-
-	for _, bank := range banks.BankArray {
-		if bank.Percussion != nil {
-			result = buildTblInstrument(bank.Percussion, result)
-		}
-
-		for _, instrument := range bank.InstArray {
-			if instrument != nil {
-				result = buildTblInstrument(instrument, result)
-			}
-		}
-	}
-
-	return result
+	return banks.LayoutTbl(nil)
 }
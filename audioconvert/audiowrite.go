@@ -126,11 +126,11 @@ func SwapEndian(data []byte) {
 	}
 }
 
-func WriteWav(filename string, wave *al64.ALWavetable, data []byte, sampleRate uint32) error {
-	var waveFile wav.Wave
-
+// DecodeWavetable returns the samples of a wavetable as 16 bit pcm
+// without modifying the wavetable or data
+func DecodeWavetable(wave *al64.ALWavetable, data []byte, sampleRate uint32) []int16 {
 	if wave.Type == al64.AL_ADPCM_WAVE {
-		var sampleCount = adpcm.NumberSamples(wave.Len)
+		var sampleCount = adpcm.NumberSamples(int32(len(data)))
 		var frames = adpcm.DecodeADPCM(&adpcm.ADPCMEncodedData{
 			NSamples:   int(sampleCount),
 			SampleRate: float64(sampleRate),
@@ -139,12 +139,75 @@ func WriteWav(filename string, wave *al64.ALWavetable, data []byte, sampleRate u
 			Frames:     adpcm.ReadFrames(data),
 		})
 
-		data = EncodeSamples(frames.Samples, binary.LittleEndian)
-		wave.Type = al64.AL_RAW16_WAVE
-	} else {
-		SwapEndian(data)
+		return frames.Samples
+	}
+
+	return DecodeSamples(data, binary.BigEndian)
+}
+
+// the loop in samples with an exclusive end
+func wavetableRawLoop(wave *al64.ALWavetable) (uint32, uint32, uint32, bool) {
+	if wave.Type == al64.AL_ADPCM_WAVE && wave.AdpcWave.Loop != nil {
+		return wave.AdpcWave.Loop.Start, wave.AdpcWave.Loop.End, wave.AdpcWave.Loop.Count, true
+	} else if wave.Type == al64.AL_RAW16_WAVE && wave.RawWave.Loop != nil {
+		return wave.RawWave.Loop.Start, wave.RawWave.Loop.End, wave.RawWave.Loop.Count, true
+	}
+
+	return 0, 0, 0, false
+}
+
+func wavSampler(wave *al64.ALWavetable, keyMap *al64.ALKeyMap, sampleRate uint32) *wav.SamplerChunk {
+	var result = &wav.SamplerChunk{
+		SamplePeriod:  1000000000 / sampleRate,
+		MIDIUnityNote: 60,
+	}
+
+	if keyMap != nil {
+		var cents = -int(int8(keyMap.Detune))
+		var unityNote = int(keyMap.KeyBase)
+
+		if cents < 0 {
+			unityNote = unityNote - 1
+			cents = cents + 100
+		}
+
+		if unityNote < 0 {
+			unityNote = 0
+			cents = 0
+		}
+
+		result.MIDIUnityNote = uint32(unityNote)
+		result.MIDIPitchFraction = uint32(float64(cents) * (1 << 32) / 100)
+	}
+
+	start, end, count, hasLoop := wavetableRawLoop(wave)
+
+	if hasLoop && end > start {
+		if count == 0xffffffff {
+			count = 0
+		}
+
+		result.Loops = append(result.Loops, wav.SampleLoop{
+			CuePointID: 1,
+			Type:       wav.LOOP_FORWARD,
+			Start:      start,
+			End:        end - 1,
+			Fraction:   0,
+			PlayCount:  count,
+		})
 	}
 
+	return result
+}
+
+// WriteWav writes the wavetable as a 16 bit wav file. The loop and
+// the tuning of the keyMap are stored in the smpl chunk with the
+// loop also marked with cue points
+func WriteWav(filename string, wave *al64.ALWavetable, data []byte, sampleRate uint32, keyMap *al64.ALKeyMap) error {
+	var waveFile wav.Wave
+
+	data = EncodeSamples(DecodeWavetable(wave, data, sampleRate), binary.LittleEndian)
+
 	waveFile.Header.Format = wav.FORMAT_PCM
 	waveFile.Header.NChannels = 1
 	waveFile.Header.SampleRate = sampleRate
@@ -154,6 +217,24 @@ func WriteWav(filename string, wave *al64.ALWavetable, data []byte, sampleRate u
 
 	waveFile.Data = data
 
+	start, end, _, hasLoop := wavetableRawLoop(wave)
+
+	if keyMap != nil || hasLoop {
+		waveFile.Sampler = wavSampler(wave, keyMap, sampleRate)
+	}
+
+	if hasLoop && end > start {
+		waveFile.Cues = []wav.CuePoint{
+			wav.CuePoint{ID: 1, Position: start, DataChunkID: wav.DATA_HEADER, SampleOffset: start},
+			wav.CuePoint{ID: 2, Position: end, DataChunkID: wav.DATA_HEADER, SampleOffset: end},
+		}
+
+		waveFile.Labels = []wav.Label{
+			wav.Label{CuePointID: 1, Text: "start"},
+			wav.Label{CuePointID: 2, Text: "end"},
+		}
+	}
+
 	EnsureDirectory(filename)
 
 	waveFileOut, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0664)
@@ -164,33 +245,21 @@ func WriteWav(filename string, wave *al64.ALWavetable, data []byte, sampleRate u
 
 	defer waveFileOut.Close()
 
-	waveFile.Serialize(waveFileOut)
-
-	return nil
+	return waveFile.Serialize(waveFileOut)
 }
 
 func WriteAiff(filename string, wave *al64.ALWavetable, data []byte, sampleRate uint32) error {
 	var aiffFile aiff.Aiff
 
 	if wave.Type == al64.AL_ADPCM_WAVE {
-		var sampleCount = adpcm.NumberSamples(wave.Len)
-		var frames = adpcm.DecodeADPCM(&adpcm.ADPCMEncodedData{
-			NSamples:   int(sampleCount),
-			SampleRate: float64(sampleRate),
-			Codebook:   ConvertCodebook(wave.AdpcWave.Book),
-			Loop:       convertLoop(wave.AdpcWave.Loop),
-			Frames:     adpcm.ReadFrames(data),
-		})
-
-		data = EncodeSamples(frames.Samples, binary.BigEndian)
-		wave.Type = al64.AL_RAW16_WAVE
+		data = EncodeSamples(DecodeWavetable(wave, data, sampleRate), binary.BigEndian)
 	}
 
 	aiffFile.Compressed = false
 
 	aiffFile.Common = &aiff.CommonChunk{
 		NumChannels:     1,
-		NumSampleFrames: wave.Len / 2,
+		NumSampleFrames: int32(len(data) / 2),
 		SampleSize:      16,
 		SampleRate:      aiff.ExtendedFromF64(float64(sampleRate)),
 		CompressionType: 0,
@@ -2,6 +2,7 @@ package audioconvert
 
 import (
 	"encoding/binary"
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -9,7 +10,42 @@ import (
 	"github.com/lambertjamesd/sfz2n64/al64"
 )
 
-func Compress(wavetable *al64.ALWavetable, codebook *adpcm.Codebook) {
+// CalculateCodebook searches for the smallest codebook that reaches the
+// target quality when settings.Search is set, name is used in the report
+func CalculateCodebook(pcmData []int16, settings *adpcm.CompressionSettings, name string) (*adpcm.Codebook, error) {
+	if settings.Search == nil {
+		return adpcm.CalculateCodebook(pcmData, settings)
+	}
+
+	codebook, result, err := adpcm.SearchCodebook(pcmData, settings, settings.Encoder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.Search.Report != nil {
+		var target = ""
+
+		if !result.MetTarget {
+			target = fmt.Sprintf(", below the target of %.1f dB", settings.Search.TargetSNR)
+		}
+
+		fmt.Fprintf(
+			settings.Search.Report,
+			"%s: order %d, %d predictors, %d byte codebook, snr %.1f dB%s\n",
+			name,
+			result.Order,
+			result.NPredictors(),
+			result.BookSize(),
+			result.SNR,
+			target,
+		)
+	}
+
+	return codebook, nil
+}
+
+func Compress(wavetable *al64.ALWavetable, codebook *adpcm.Codebook, encoder adpcm.Encoder) error {
 	if wavetable.Type == al64.AL_RAW16_WAVE {
 		var adpcmLoop *adpcm.Loop = nil
 
@@ -22,14 +58,19 @@ func Compress(wavetable *al64.ALWavetable, codebook *adpcm.Codebook) {
 			}
 		}
 
-		result := adpcm.EncodeADPCM(
+		result, err := adpcm.EncodeADPCMWithEncoder(
 			&adpcm.PCMEncodedData{Samples: DecodeSamples(wavetable.DataFromTable, binary.BigEndian)},
 			codebook,
 			adpcmLoop,
 			false,
 			16,
+			encoder,
 		)
 
+		if err != nil {
+			return err
+		}
+
 		wavetable.DataFromTable = adpcm.EnocdeFrames(result.Frames)
 		wavetable.Len = int32(len(wavetable.DataFromTable))
 		wavetable.Type = al64.AL_ADPCM_WAVE
@@ -47,6 +88,8 @@ func Compress(wavetable *al64.ALWavetable, codebook *adpcm.Codebook) {
 
 		wavetable.RawWave.Loop = nil
 	}
+
+	return nil
 }
 
 func CompressWithSettings(wavetable *al64.ALWavetable, fileLocation string, compressionSettings *adpcm.CompressionSettings) error {
@@ -70,20 +113,25 @@ func CompressWithSettings(wavetable *al64.ALWavetable, fileLocation string, comp
 		codebook, err = adpcm.ParseCodebook(file)
 
 		if err != nil {
-			return err
+			return &SoundError{existingTable, err}
 		}
 	} else {
-		codebook, err = adpcm.CalculateCodebook(
+		codebook, err = CalculateCodebook(
 			DecodeSamples(wavetable.DataFromTable, binary.BigEndian),
 			compressionSettings,
+			filepath.Base(fileLocation),
 		)
 
 		if err != nil {
-			return err
+			return &SoundError{fileLocation, err}
 		}
 	}
 
-	Compress(wavetable, codebook)
+	err := Compress(wavetable, codebook, compressionSettings.Encoder)
+
+	if err != nil {
+		return &SoundError{fileLocation, err}
+	}
 
 	return nil
 }
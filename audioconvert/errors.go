@@ -0,0 +1,46 @@
+package audioconvert
+
+import "fmt"
+
+// SoundError wraps an error with the sound that caused it
+type SoundError struct {
+	// the filename of the sound or where it is in the bank file
+	Sound string
+	Err   error
+}
+
+func (err *SoundError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Sound, err.Err.Error())
+}
+
+func (err *SoundError) Unwrap() error {
+	return err.Err
+}
+
+// an instrument of -1 is the percussion instrument
+func instrumentLocation(instrument int) string {
+	if instrument == -1 {
+		return "percussion"
+	}
+
+	return fmt.Sprintf("instrument %d", instrument)
+}
+
+func soundLocation(bank int, instrument int, sound int) string {
+	return fmt.Sprintf("bank %d %s sound %d", bank, instrumentLocation(instrument), sound)
+}
+
+// adds to the start of the location of a SoundError
+func wrapSoundError(location string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	soundErr, ok := err.(*SoundError)
+
+	if ok {
+		return &SoundError{location + " " + soundErr.Sound, soundErr.Err}
+	}
+
+	return &SoundError{location, err}
+}
@@ -0,0 +1,96 @@
+package audioconvert
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// PCM_CHANNEL_MIX averages all of the channels together
+const PCM_CHANNEL_MIX = -1
+
+type PCMConversionSettings struct {
+	// the index of the channel to use or PCM_CHANNEL_MIX
+	Channel int
+	// add triangular dither when reducing samples to 16 bits
+	Dither bool
+}
+
+func DefaultPCMConversionSettings() PCMConversionSettings {
+	return PCMConversionSettings{
+		PCM_CHANNEL_MIX,
+		false,
+	}
+}
+
+func selectChannel(channels [][]float64, settings *PCMConversionSettings) ([]float64, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("No channels to convert")
+	}
+
+	// mono audio always uses its only channel
+	if len(channels) == 1 {
+		return channels[0], nil
+	}
+
+	if settings.Channel == PCM_CHANNEL_MIX {
+		var result = make([]float64, len(channels[0]))
+
+		for _, channel := range channels {
+			for index, sample := range channel {
+				result[index] += sample
+			}
+		}
+
+		for index := range result {
+			result[index] /= float64(len(channels))
+		}
+
+		return result, nil
+	}
+
+	if settings.Channel < 0 || settings.Channel >= len(channels) {
+		return nil, errors.New(fmt.Sprintf("Cannot use channel %d of audio with %d channels", settings.Channel, len(channels)))
+	}
+
+	return channels[settings.Channel], nil
+}
+
+// ConvertToPCM16 reduces a set of channels with samples in the
+// range -1 to 1 to a single channel of 16 bit samples. bitDepth is
+// the precision of the source and is used to skip dither when no
+// precision is lost
+func ConvertToPCM16(channels [][]float64, bitDepth int, settings *PCMConversionSettings) ([]int16, error) {
+	samples, err := selectChannel(channels, settings)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var isExact = bitDepth <= 16 && (len(channels) == 1 || settings.Channel != PCM_CHANNEL_MIX)
+	var dither = settings.Dither && !isExact
+	// a fixed seed keeps the output the same between runs
+	var random = rand.New(rand.NewSource(1))
+	var result = make([]int16, len(samples))
+
+	for index, sample := range samples {
+		var value = sample * 32768
+
+		if dither {
+			value += random.Float64() - random.Float64()
+		}
+
+		value = math.Round(value)
+
+		if value > math.MaxInt16 {
+			value = math.MaxInt16
+		} else if value < math.MinInt16 {
+			value = math.MinInt16
+		}
+
+		result[index] = int16(value)
+	}
+
+	return result, nil
+}
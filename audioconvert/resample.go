@@ -2,8 +2,11 @@ package audioconvert
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
 
+	"github.com/lambertjamesd/sfz2n64/adpcm"
 	"github.com/lambertjamesd/sfz2n64/al64"
 )
 
@@ -33,62 +36,158 @@ func GetSample(input []int16, at float32) int16 {
 	}
 }
 
-func Resample(input []int16, from int, to int) []int16 {
+func Resample(input []int16, from int, to int, quality ResampleQuality) []int16 {
 	var result []int16 = make([]int16, ConvertSampleLocation(len(input), to, from))
+	var sampler = newResampler(from, to, quality)
 
-	var scale = float32(from) / float32(to)
+	var scale = float64(from) / float64(to)
 
 	for index, _ := range result {
-		result[index] = GetSample(input, float32(index)*scale)
+		result[index] = sampler.sample(input, float64(index)*scale)
 	}
 
 	return result
 }
 
-func ResampleLooped(input []int16, from int, to int, loopStart int, loopEnd int) []int16 {
+func ResampleLooped(input []int16, from int, to int, loopStart int, loopEnd int, quality ResampleQuality) []int16 {
 	var result []int16 = make([]int16, ConvertSampleLocation(len(input), to, from))
-	var scale = float32(from) / float32(to)
+	var sampler = newResampler(from, to, quality)
+	var scale = float64(from) / float64(to)
 
 	var convertedStart = ConvertSampleLocation(loopStart, to, from)
 	var convertedEnd = ConvertSampleLocation(loopEnd, to, from)
 
 	// ensure that the first sample in the loop is accurate
-	var scaleOffset = float32(loopStart) - float32(convertedStart)*scale
+	var scaleOffset = float64(loopStart) - float64(convertedStart)*scale
 
 	for index := 0; index < convertedEnd && index < len(result); index++ {
-		result[index] = GetSample(input, float32(index)*scale+scaleOffset)
+		result[index] = sampler.sample(input, float64(index)*scale+scaleOffset)
 	}
 
-	scaleOffset = float32(loopEnd) - float32(convertedEnd)*scale
+	scaleOffset = float64(loopEnd) - float64(convertedEnd)*scale
 
 	for index := convertedStart; index < convertedEnd && index < len(result); index++ {
 		var lerp = float32(index-convertedStart) / float32(convertedEnd-1-convertedStart)
 
-		var inputSample = GetSample(input, float32(index)*scale+scaleOffset)
+		var inputSample = sampler.sample(input, float64(index)*scale+scaleOffset)
 		result[index] = lerpSample(result[index], inputSample, lerp)
 	}
 
 	for index := convertedEnd; index < len(result); index++ {
-		result[index] = GetSample(input, float32(index)*scale+scaleOffset)
+		result[index] = sampler.sample(input, float64(index)*scale+scaleOffset)
 	}
 
 	return result
 }
 
-func ResampleWavetable(wavetable *al64.ALWavetable, to int, from int) *al64.ALWavetable {
-	if wavetable == nil {
-		return nil
-	} else if wavetable.Type != al64.AL_RAW16_WAVE {
-		// TODO resample compressed data
-		return wavetable
+type ResampleSettings struct {
+	// the filter used when resampling audio
+	Quality ResampleQuality
+	// the settings used to calculate a new codebook for resampled
+	// adpcm wavetables. When nil the original codebook is reused
+	CompressionSettings *adpcm.CompressionSettings
+}
+
+// an uncompressed copy of a compressed wavetable with the same loop
+func decompressWavetable(wavetable *al64.ALWavetable, sampleRate uint32) *al64.ALWavetable {
+	var samples = DecodeWavetable(wavetable, wavetable.DataFromTable, sampleRate)
+
+	var raw = &al64.ALWavetable{
+		Base:           0,
+		Len:            int32(2 * len(samples)),
+		Type:           al64.AL_RAW16_WAVE,
+		AdpcWave:       al64.ALADPCMWaveInfo{Loop: nil, Book: nil},
+		RawWave:        al64.ALRAWWaveInfo{Loop: nil},
+		DataFromTable:  EncodeSamples(samples, binary.BigEndian),
+		FileSampleRate: wavetable.FileSampleRate,
 	}
 
-	var result al64.ALWavetable
+	if wavetable.AdpcWave.Loop != nil {
+		raw.RawWave.Loop = &al64.ALRawLoop{
+			Start: wavetable.AdpcWave.Loop.Start,
+			End:   wavetable.AdpcWave.Loop.End,
+			Count: wavetable.AdpcWave.Loop.Count,
+		}
+	}
+
+	return raw
+}
+
+// decodes the wavetable, resamples it, then encodes it again
+func resampleCompressedWavetable(wavetable *al64.ALWavetable, to int, from int, settings *ResampleSettings) (*al64.ALWavetable, error) {
+	var raw = decompressWavetable(wavetable, uint32(from))
+	raw.FileSampleRate = uint32(from)
+
+	var loopCount uint32 = 0
+
+	if wavetable.AdpcWave.Loop != nil {
+		loopCount = wavetable.AdpcWave.Loop.Count
+	}
+
+	result, err := resampleRawWavetable(raw, to, from, settings)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var codebook *adpcm.Codebook
+	var encoder = adpcm.ENCODER_GREEDY
+
+	if settings.CompressionSettings != nil {
+		codebook, err = CalculateCodebook(
+			DecodeSamples(result.DataFromTable, binary.BigEndian),
+			settings.CompressionSettings,
+			fmt.Sprintf("wavetable resampled from %d to %d Hz", from, to),
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		encoder = settings.CompressionSettings.Encoder
+	} else {
+		codebook = ConvertCodebook(wavetable.AdpcWave.Book)
+	}
+
+	err = Compress(result, codebook, encoder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the encoder recalculates the loop state but not the loop count
+	if result.AdpcWave.Loop != nil {
+		result.AdpcWave.Loop.Count = loopCount
+	}
+
+	return result, nil
+}
+
+func ResampleWavetable(wavetable *al64.ALWavetable, to int, from int, settings *ResampleSettings) (*al64.ALWavetable, error) {
+	if wavetable == nil {
+		return nil, nil
+	}
 
 	if wavetable.FileSampleRate != 0 {
 		from = int(wavetable.FileSampleRate)
 	}
 
+	if wavetable.Type == al64.AL_ADPCM_WAVE {
+		if wavetable.AdpcWave.Book == nil {
+			return nil, errors.New("Cannot resample compressed wavetable without a codebook")
+		}
+
+		return resampleCompressedWavetable(wavetable, to, from, settings)
+	} else if wavetable.Type != al64.AL_RAW16_WAVE {
+		return nil, errors.New(fmt.Sprintf("Cannot resample wavetable of type %d", wavetable.Type))
+	}
+
+	return resampleRawWavetable(wavetable, to, from, settings)
+}
+
+func resampleRawWavetable(wavetable *al64.ALWavetable, to int, from int, settings *ResampleSettings) (*al64.ALWavetable, error) {
+	var result al64.ALWavetable
+
 	var samples = DecodeSamples(wavetable.DataFromTable, binary.BigEndian)
 	var resampled []int16
 
@@ -99,11 +198,11 @@ func ResampleWavetable(wavetable *al64.ALWavetable, to int, from int) *al64.ALWa
 		loop.End = uint32(ConvertSampleLocation(int(wavetable.RawWave.Loop.End), to, from))
 		loop.Count = wavetable.RawWave.Loop.Count
 
-		resampled = ResampleLooped(samples, from, to, int(wavetable.RawWave.Loop.Start), int(wavetable.RawWave.Loop.End))
+		resampled = ResampleLooped(samples, from, to, int(wavetable.RawWave.Loop.Start), int(wavetable.RawWave.Loop.End), settings.Quality)
 
 		result.RawWave.Loop = &loop
 	} else {
-		resampled = Resample(samples, from, to)
+		resampled = Resample(samples, from, to, settings.Quality)
 	}
 
 	result.Base = 0
@@ -112,7 +211,7 @@ func ResampleWavetable(wavetable *al64.ALWavetable, to int, from int) *al64.ALWa
 	result.DataFromTable = EncodeSamples(resampled, binary.BigEndian)
 	result.FileSampleRate = uint32(to)
 
-	return &result
+	return &result, nil
 }
 
 func ResampleEnvelope(envelope *al64.ALEnvelope, to int, from int) *al64.ALEnvelope {
@@ -131,21 +230,27 @@ func ResampleEnvelope(envelope *al64.ALEnvelope, to int, from int) *al64.ALEnvel
 	return &result
 }
 
-func ResampleSound(sound *al64.ALSound, to int, from int) *al64.ALSound {
+func ResampleSound(sound *al64.ALSound, to int, from int, settings *ResampleSettings) (*al64.ALSound, error) {
 	var result al64.ALSound
 
+	wavetable, err := ResampleWavetable(sound.Wavetable, to, from, settings)
+
+	if err != nil {
+		return nil, err
+	}
+
 	result.Envelope = ResampleEnvelope(sound.Envelope, to, from)
 	result.KeyMap = sound.KeyMap
-	result.Wavetable = ResampleWavetable(sound.Wavetable, to, from)
+	result.Wavetable = wavetable
 	result.SamplePan = sound.SamplePan
 	result.SampleVolume = sound.SampleVolume
 
-	return &result
+	return &result, nil
 }
 
-func ResampleInstrument(instrument *al64.ALInstrument, to int, from int) *al64.ALInstrument {
+func ResampleInstrument(instrument *al64.ALInstrument, to int, from int, settings *ResampleSettings) (*al64.ALInstrument, error) {
 	if instrument == nil {
-		return nil
+		return nil, nil
 	}
 
 	var result al64.ALInstrument
@@ -163,32 +268,55 @@ func ResampleInstrument(instrument *al64.ALInstrument, to int, from int) *al64.A
 	result.VibDelay = instrument.VibDelay
 	result.BendRange = instrument.BendRange
 
-	for _, sound := range instrument.SoundArray {
-		result.SoundArray = append(result.SoundArray, ResampleSound(sound, to, from))
+	for index, sound := range instrument.SoundArray {
+		resampled, err := ResampleSound(sound, to, from, settings)
+
+		if err != nil {
+			return nil, wrapSoundError(fmt.Sprintf("sound %d", index), err)
+		}
+
+		result.SoundArray = append(result.SoundArray, resampled)
 	}
 
-	return &result
+	return &result, nil
 }
 
-func ResampleBank(bank *al64.ALBank, to int) *al64.ALBank {
+func ResampleBank(bank *al64.ALBank, to int, settings *ResampleSettings) (*al64.ALBank, error) {
 	var result al64.ALBank
+	var err error
 
 	result.SampleRate = uint32(to)
-	result.Percussion = ResampleInstrument(bank.Percussion, to, int(bank.SampleRate))
+	result.Percussion, err = ResampleInstrument(bank.Percussion, to, int(bank.SampleRate), settings)
 
-	for _, instrument := range bank.InstArray {
-		result.InstArray = append(result.InstArray, ResampleInstrument(instrument, to, int(bank.SampleRate)))
+	if err != nil {
+		return nil, wrapSoundError(instrumentLocation(-1), err)
 	}
 
-	return &result
+	for index, instrument := range bank.InstArray {
+		resampled, err := ResampleInstrument(instrument, to, int(bank.SampleRate), settings)
+
+		if err != nil {
+			return nil, wrapSoundError(instrumentLocation(index), err)
+		}
+
+		result.InstArray = append(result.InstArray, resampled)
+	}
+
+	return &result, nil
 }
 
-func ResampleBankFile(bankfile *al64.ALBankFile, to int) *al64.ALBankFile {
+func ResampleBankFile(bankfile *al64.ALBankFile, to int, settings *ResampleSettings) (*al64.ALBankFile, error) {
 	var result al64.ALBankFile
 
-	for _, input := range bankfile.BankArray {
-		result.BankArray = append(result.BankArray, ResampleBank(input, to))
+	for index, input := range bankfile.BankArray {
+		resampled, err := ResampleBank(input, to, settings)
+
+		if err != nil {
+			return nil, wrapSoundError(fmt.Sprintf("bank %d", index), err)
+		}
+
+		result.BankArray = append(result.BankArray, resampled)
 	}
 
-	return &result
+	return &result, nil
 }
@@ -0,0 +1,185 @@
+package audioconvert
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/lambertjamesd/sfz2n64/al64"
+)
+
+type SampleRateStrategy int
+
+const (
+	// resample each sound to the sample rate of the bank
+	SAMPLE_RATE_RESAMPLE SampleRateStrategy = iota
+	// keep the sound data and adjust the keymap to play at the correct pitch
+	SAMPLE_RATE_DETUNE
+	// leave sounds at the wrong sample rate unchanged
+	SAMPLE_RATE_IGNORE
+)
+
+var sampleRateStrategyNames = map[string]SampleRateStrategy{
+	"resample": SAMPLE_RATE_RESAMPLE,
+	"detune":   SAMPLE_RATE_DETUNE,
+	"ignore":   SAMPLE_RATE_IGNORE,
+}
+
+func ParseSampleRateStrategy(name string) (SampleRateStrategy, error) {
+	strategy, ok := sampleRateStrategyNames[name]
+
+	if !ok {
+		return SAMPLE_RATE_RESAMPLE, errors.New(fmt.Sprintf("Unknown sample rate strategy '%s'. Expected resample, detune, or ignore", name))
+	}
+
+	return strategy, nil
+}
+
+// calls callback for every sound with a wavetable, the percussion instrument has an index of -1
+func forEachBankSound(bank *al64.ALBank, callback func(sound *al64.ALSound, instrument int, index int)) {
+	var instruments = append([]*al64.ALInstrument{bank.Percussion}, bank.InstArray...)
+
+	for instIndex, instrument := range instruments {
+		if instrument == nil {
+			continue
+		}
+
+		for index, sound := range instrument.SoundArray {
+			if sound != nil && sound.Wavetable != nil {
+				callback(sound, instIndex-1, index)
+			}
+		}
+	}
+}
+
+// ChooseBankSampleRate picks the sample rate used by the most sounds
+// in the bank, preferring the higher sample rate when there is a tie
+func ChooseBankSampleRate(bank *al64.ALBank) uint32 {
+	var counts = make(map[uint32]int)
+	var result uint32 = 0
+
+	forEachBankSound(bank, func(sound *al64.ALSound, instrument int, index int) {
+		var sampleRate = sound.Wavetable.FileSampleRate
+
+		if sampleRate == 0 {
+			return
+		}
+
+		counts[sampleRate] = counts[sampleRate] + 1
+
+		if counts[sampleRate] > counts[result] || (counts[sampleRate] == counts[result] && sampleRate > result) {
+			result = sampleRate
+		}
+	})
+
+	return result
+}
+
+// returns a copy of the keymap that plays a sound recorded at
+// sampleRate at the correct pitch in a bank using bankSampleRate
+func detuneKeyMap(keyMap *al64.ALKeyMap, sampleRate uint32, bankSampleRate uint32) (*al64.ALKeyMap, error) {
+	var result = *keyMap
+	var cents = int(int8(keyMap.Detune)) + int(math.Round(1200*math.Log2(float64(sampleRate)/float64(bankSampleRate))))
+	var keyBase = int(keyMap.KeyBase)
+
+	for cents > 50 {
+		cents -= 100
+		keyBase--
+	}
+
+	for cents < -50 {
+		cents += 100
+		keyBase++
+	}
+
+	if keyBase < 0 || keyBase > 127 {
+		return nil, errors.New(fmt.Sprintf("Cannot play a sound with a sample rate of %d in a bank with a sample rate of %d without resampling", sampleRate, bankSampleRate))
+	}
+
+	result.KeyBase = uint8(keyBase)
+	result.Detune = uint8(cents)
+
+	return &result, nil
+}
+
+// NormalizeBankSampleRates fixes any sounds with a sample rate different
+// from the bank they are in so they play at the correct pitch. Returns
+// true if any sounds were changed
+func NormalizeBankSampleRates(bankFile *al64.ALBankFile, strategy SampleRateStrategy, settings *ResampleSettings) (bool, error) {
+	if strategy == SAMPLE_RATE_IGNORE {
+		return false, nil
+	}
+
+	var changed = false
+	var err error = nil
+
+	type resampleKey struct {
+		wavetable  *al64.ALWavetable
+		sampleRate uint32
+	}
+
+	var resampled = make(map[resampleKey]*al64.ALWavetable)
+	// keymaps and wavetables can be shared so the adjustments
+	// are based on the original sample rates
+	var originalRates = make(map[*al64.ALWavetable]uint32)
+
+	for bankIndex, bank := range bankFile.BankArray {
+		if bank.SampleRate == 0 {
+			bank.SampleRate = ChooseBankSampleRate(bank)
+		}
+
+		forEachBankSound(bank, func(sound *al64.ALSound, instrument int, index int) {
+			if err != nil {
+				return
+			}
+
+			sampleRate, ok := originalRates[sound.Wavetable]
+
+			if !ok {
+				sampleRate = sound.Wavetable.FileSampleRate
+			}
+
+			if sampleRate == 0 || sampleRate == bank.SampleRate {
+				return
+			}
+
+			if strategy == SAMPLE_RATE_RESAMPLE {
+				var key = resampleKey{sound.Wavetable, bank.SampleRate}
+				existing, ok := resampled[key]
+
+				if !ok {
+					existing, err = ResampleWavetable(sound.Wavetable, int(bank.SampleRate), int(sampleRate), settings)
+
+					if err != nil {
+						err = &SoundError{soundLocation(bankIndex, instrument, index), err}
+						return
+					}
+
+					resampled[key] = existing
+				}
+
+				sound.Wavetable = existing
+			} else if sound.KeyMap != nil {
+				var keyMap *al64.ALKeyMap
+				keyMap, err = detuneKeyMap(sound.KeyMap, sampleRate, bank.SampleRate)
+
+				if err != nil {
+					err = &SoundError{soundLocation(bankIndex, instrument, index), err}
+					return
+				}
+
+				originalRates[sound.Wavetable] = sampleRate
+				sound.Wavetable.FileSampleRate = bank.SampleRate
+				sound.KeyMap = keyMap
+			}
+
+			changed = true
+		})
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return changed, nil
+}
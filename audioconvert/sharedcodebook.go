@@ -0,0 +1,328 @@
+package audioconvert
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/lambertjamesd/sfz2n64/adpcm"
+	"github.com/lambertjamesd/sfz2n64/al64"
+)
+
+// the number of autocorrelation lags used to compare sounds
+const sharedCodebookFeatures = 8
+const sharedCodebookIterations = 32
+
+type SharedCodebookSound struct {
+	Bank int
+	// -1 for the percussion instrument
+	Instrument int
+	Sound      int
+	Group      int
+	// the snr of encoding the sound again with its own codebook
+	OriginalSNR float64
+	SharedSNR   float64
+}
+
+type SharedCodebookReport struct {
+	OriginalBooks    int
+	OriginalBookSize int
+	Books            int
+	BookSize         int
+	Sounds           []SharedCodebookSound
+}
+
+type sharedCodebookWavetable struct {
+	wavetable *al64.ALWavetable
+	samples   []int16
+	features  []float64
+	group     int
+	location  SharedCodebookSound
+}
+
+func alBookSize(book *al64.ALADPCMBook) int {
+	return 8 + 2*len(book.Book)
+}
+
+// the normalized autocorrelation of the samples which
+// describes the spectral envelope the codebook has to predict
+func autocorrelationFeatures(samples []int16) []float64 {
+	var result = make([]float64, sharedCodebookFeatures)
+	var energy float64 = 0
+
+	for _, sample := range samples {
+		energy = energy + float64(sample)*float64(sample)
+	}
+
+	if energy == 0 {
+		return result
+	}
+
+	for lag := 1; lag <= sharedCodebookFeatures; lag = lag + 1 {
+		var sum float64 = 0
+
+		for i := lag; i < len(samples); i = i + 1 {
+			sum = sum + float64(samples[i])*float64(samples[i-lag])
+		}
+
+		result[lag-1] = sum / energy
+	}
+
+	return result
+}
+
+func featureDistance(a []float64, b []float64) float64 {
+	var result float64 = 0
+
+	for i := range a {
+		result = result + (a[i]-b[i])*(a[i]-b[i])
+	}
+
+	return result
+}
+
+// groups the wavetables using k-means starting from centers
+// that are as far from each other as possible
+func clusterWavetables(wavetables []*sharedCodebookWavetable, groupCount int) {
+	var centers = [][]float64{wavetables[0].features}
+
+	for len(centers) < groupCount {
+		var farthest = 0
+		var farthestDistance float64 = -1
+
+		for index, wavetable := range wavetables {
+			var distance = math.Inf(1)
+
+			for _, center := range centers {
+				distance = math.Min(distance, featureDistance(wavetable.features, center))
+			}
+
+			if distance > farthestDistance {
+				farthest = index
+				farthestDistance = distance
+			}
+		}
+
+		centers = append(centers, wavetables[farthest].features)
+	}
+
+	for iteration := 0; iteration < sharedCodebookIterations; iteration = iteration + 1 {
+		var changed = false
+
+		for _, wavetable := range wavetables {
+			var closest = 0
+
+			for index, center := range centers {
+				if featureDistance(wavetable.features, center) < featureDistance(wavetable.features, centers[closest]) {
+					closest = index
+				}
+			}
+
+			if closest != wavetable.group || iteration == 0 {
+				wavetable.group = closest
+				changed = true
+			}
+		}
+
+		if !changed {
+			break
+		}
+
+		for index := range centers {
+			var sum = make([]float64, sharedCodebookFeatures)
+			var count = 0
+
+			for _, wavetable := range wavetables {
+				if wavetable.group == index {
+					for i, value := range wavetable.features {
+						sum[i] = sum[i] + value
+					}
+					count = count + 1
+				}
+			}
+
+			if count == 0 {
+				continue
+			}
+
+			for i := range sum {
+				sum[i] = sum[i] / float64(count)
+			}
+
+			centers[index] = sum
+		}
+	}
+}
+
+// ShareCodebooks groups the compressed sounds of the bank file by how
+// similar they sound and compresses every sound in a group with a
+// single codebook calculated from all of them. Sounds in the same
+// group use the same ALADPCMBook so it is only stored once
+func ShareCodebooks(bankFile *al64.ALBankFile, groupCount int, settings *adpcm.CompressionSettings) (*SharedCodebookReport, error) {
+	var wavetables []*sharedCodebookWavetable = nil
+	var found = make(map[*al64.ALWavetable]bool)
+	var originalBooks = make(map[*al64.ALADPCMBook]bool)
+
+	var report = &SharedCodebookReport{
+		OriginalBooks:    0,
+		OriginalBookSize: 0,
+		Books:            0,
+		BookSize:         0,
+		Sounds:           nil,
+	}
+
+	for bankIndex, bank := range bankFile.BankArray {
+		var instruments = append([]*al64.ALInstrument{bank.Percussion}, bank.InstArray...)
+
+		for instIndex, instrument := range instruments {
+			if instrument == nil {
+				continue
+			}
+
+			for soundIndex, sound := range instrument.SoundArray {
+				if sound == nil || sound.Wavetable == nil || found[sound.Wavetable] {
+					continue
+				}
+
+				var wavetable = sound.Wavetable
+				found[wavetable] = true
+
+				if wavetable.Type != al64.AL_ADPCM_WAVE || wavetable.AdpcWave.Book == nil {
+					continue
+				}
+
+				if !originalBooks[wavetable.AdpcWave.Book] {
+					originalBooks[wavetable.AdpcWave.Book] = true
+					report.OriginalBooks = report.OriginalBooks + 1
+					report.OriginalBookSize = report.OriginalBookSize + alBookSize(wavetable.AdpcWave.Book)
+				}
+
+				var samples = DecodeWavetable(wavetable, wavetable.DataFromTable, bank.SampleRate)
+
+				wavetables = append(wavetables, &sharedCodebookWavetable{
+					wavetable: wavetable,
+					samples:   samples,
+					features:  autocorrelationFeatures(samples),
+					group:     0,
+					location: SharedCodebookSound{
+						Bank:        bankIndex,
+						Instrument:  instIndex - 1,
+						Sound:       soundIndex,
+						Group:       0,
+						OriginalSNR: 0,
+						SharedSNR:   0,
+					},
+				})
+			}
+		}
+	}
+
+	if len(wavetables) == 0 {
+		return report, nil
+	}
+
+	if groupCount > len(wavetables) {
+		groupCount = len(wavetables)
+	}
+
+	clusterWavetables(wavetables, groupCount)
+
+	for group := 0; group < groupCount; group = group + 1 {
+		var combined []int16 = nil
+
+		for _, wavetable := range wavetables {
+			if wavetable.group == group {
+				combined = append(combined, wavetable.samples...)
+			}
+		}
+
+		if len(combined) == 0 {
+			continue
+		}
+
+		codebook, err := CalculateCodebook(combined, settings, fmt.Sprintf("shared codebook %d", report.Books))
+
+		if err != nil {
+			return nil, err
+		}
+
+		var book = ConvertCodebookToAL64(codebook)
+		report.BookSize = report.BookSize + alBookSize(book)
+
+		for _, wavetable := range wavetables {
+			if wavetable.group != group {
+				continue
+			}
+
+			var location = wavetable.location
+			var name = soundLocation(location.Bank, location.Instrument, location.Sound)
+			location.Group = report.Books
+
+			location.OriginalSNR, err = adpcm.MeasureSNR(wavetable.samples, ConvertCodebook(wavetable.wavetable.AdpcWave.Book), settings.Encoder)
+
+			if err != nil {
+				return nil, &SoundError{name, err}
+			}
+
+			location.SharedSNR, err = adpcm.MeasureSNR(wavetable.samples, codebook, settings.Encoder)
+
+			if err != nil {
+				return nil, &SoundError{name, err}
+			}
+
+			report.Sounds = append(report.Sounds, location)
+
+			var raw = decompressWavetable(wavetable.wavetable, wavetable.wavetable.FileSampleRate)
+			err = Compress(raw, codebook, settings.Encoder)
+
+			if err != nil {
+				return nil, &SoundError{name, err}
+			}
+
+			raw.AdpcWave.Book = book
+
+			// the encoder recalculates the loop state but not the loop count
+			if raw.AdpcWave.Loop != nil && wavetable.wavetable.AdpcWave.Loop != nil {
+				raw.AdpcWave.Loop.Count = wavetable.wavetable.AdpcWave.Loop.Count
+			}
+
+			// changed in place since other sounds may share the wavetable
+			*wavetable.wavetable = *raw
+		}
+
+		report.Books = report.Books + 1
+	}
+
+	return report, nil
+}
+
+func (report *SharedCodebookReport) Write(out io.Writer) {
+	fmt.Fprintf(
+		out,
+		"%d codebooks using %d bytes -> %d codebooks using %d bytes, saved %d bytes\n",
+		report.OriginalBooks,
+		report.OriginalBookSize,
+		report.Books,
+		report.BookSize,
+		report.OriginalBookSize-report.BookSize,
+	)
+
+	var totalChange float64 = 0
+
+	for _, sound := range report.Sounds {
+		fmt.Fprintf(
+			out,
+			"%s: codebook %d, snr %.1f dB -> %.1f dB\n",
+			soundLocation(sound.Bank, sound.Instrument, sound.Sound),
+			sound.Group,
+			sound.OriginalSNR,
+			sound.SharedSNR,
+		)
+
+		totalChange = totalChange + sound.SharedSNR - sound.OriginalSNR
+	}
+
+	if len(report.Sounds) > 0 {
+		fmt.Fprintf(out, "average snr change %.1f dB\n", totalChange/float64(len(report.Sounds)))
+	}
+}
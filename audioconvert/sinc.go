@@ -0,0 +1,190 @@
+package audioconvert
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+type ResampleQuality int
+
+const (
+	RESAMPLE_LINEAR ResampleQuality = iota
+	RESAMPLE_LOW
+	RESAMPLE_MEDIUM
+	RESAMPLE_HIGH
+)
+
+var resampleQualityNames = map[string]ResampleQuality{
+	"linear": RESAMPLE_LINEAR,
+	"low":    RESAMPLE_LOW,
+	"medium": RESAMPLE_MEDIUM,
+	"high":   RESAMPLE_HIGH,
+}
+
+func ParseResampleQuality(name string) (ResampleQuality, error) {
+	quality, ok := resampleQualityNames[name]
+
+	if !ok {
+		return RESAMPLE_LINEAR, errors.New(fmt.Sprintf("Unknown resample quality '%s'. Expected linear, low, medium, or high", name))
+	}
+
+	return quality, nil
+}
+
+type sincFilterSettings struct {
+	// the number of zero crossings on each side of the filter
+	halfWidth int
+	// the shape of the kaiser window
+	beta float64
+	// how close to nyquist the cutoff frequency is
+	rolloff float64
+}
+
+var sincFilterQualities = map[ResampleQuality]sincFilterSettings{
+	RESAMPLE_LOW:    sincFilterSettings{8, 6, 0.9},
+	RESAMPLE_MEDIUM: sincFilterSettings{16, 8, 0.94},
+	RESAMPLE_HIGH:   sincFilterSettings{32, 10, 0.97},
+}
+
+// the number of points in the filter table between each zero crossing
+const sincTableResolution = 512
+
+type sincFilter struct {
+	halfWidth int
+	table     []float64
+}
+
+// filters are never changed once built so they can be shared between conversions
+var sincFilterCache = make(map[ResampleQuality]*sincFilter)
+var sincFilterCacheLock sync.Mutex
+
+// modified bessel function of the first kind
+func besselI0(x float64) float64 {
+	var result float64 = 1
+	var term float64 = 1
+
+	for k := 1; k < 50; k = k + 1 {
+		term = term * (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		result = result + term
+
+		if term < result*1e-12 {
+			break
+		}
+	}
+
+	return result
+}
+
+func getSincFilter(quality ResampleQuality) *sincFilter {
+	sincFilterCacheLock.Lock()
+	defer sincFilterCacheLock.Unlock()
+
+	cached, ok := sincFilterCache[quality]
+
+	if ok {
+		return cached
+	}
+
+	var settings = sincFilterQualities[quality]
+	var size = settings.halfWidth*sincTableResolution + 1
+	var result = &sincFilter{
+		halfWidth: settings.halfWidth,
+		table:     make([]float64, size+1),
+	}
+
+	var windowScale = 1 / besselI0(settings.beta)
+
+	for i := 0; i < size; i = i + 1 {
+		var x = float64(i) / sincTableResolution
+		var sinc float64 = 1
+
+		if i != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+
+		var windowPos = x / float64(settings.halfWidth)
+		var window = besselI0(settings.beta*math.Sqrt(math.Max(0, 1-windowPos*windowPos))) * windowScale
+
+		result.table[i] = sinc * window
+	}
+
+	sincFilterCache[quality] = result
+
+	return result
+}
+
+// the value of the filter at a distance measured in zero crossings
+func (filter *sincFilter) at(distance float64) float64 {
+	distance = math.Abs(distance) * sincTableResolution
+	var index = int(distance)
+
+	if index+1 >= len(filter.table) {
+		return 0
+	}
+
+	var lerp = distance - float64(index)
+
+	return filter.table[index]*(1-lerp) + filter.table[index+1]*lerp
+}
+
+type resampler struct {
+	quality ResampleQuality
+	filter  *sincFilter
+	// cutoff frequency relative to the nyquist frequency of the input
+	cutoff float64
+}
+
+func newResampler(from int, to int, quality ResampleQuality) *resampler {
+	var result = &resampler{
+		quality: quality,
+		filter:  nil,
+		cutoff:  1,
+	}
+
+	if result.quality != RESAMPLE_LINEAR {
+		result.filter = getSincFilter(result.quality)
+		result.cutoff = sincFilterQualities[result.quality].rolloff
+
+		if to < from {
+			result.cutoff = result.cutoff * float64(to) / float64(from)
+		}
+	}
+
+	return result
+}
+
+func (r *resampler) sample(input []int16, at float64) int16 {
+	if r.filter == nil {
+		return GetSample(input, float32(at))
+	}
+
+	// the filter gets wider as the cutoff frequency decreases
+	var radius = float64(r.filter.halfWidth) / r.cutoff
+	var first = int(math.Ceil(at - radius))
+	var last = int(math.Floor(at + radius))
+	var result float64 = 0
+
+	for index := first; index <= last; index = index + 1 {
+		var clamped = index
+
+		if clamped < 0 {
+			clamped = 0
+		} else if clamped >= len(input) {
+			clamped = len(input) - 1
+		}
+
+		result = result + float64(input[clamped])*r.filter.at((at-float64(index))*r.cutoff)
+	}
+
+	result = math.Round(result * r.cutoff)
+
+	if result > math.MaxInt16 {
+		return math.MaxInt16
+	} else if result < math.MinInt16 {
+		return math.MinInt16
+	}
+
+	return int16(result)
+}
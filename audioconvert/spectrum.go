@@ -0,0 +1,99 @@
+package audioconvert
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// in place radix 2 fft, len(data) must be a power of 2
+func fft(data []complex128) {
+	var n = len(data)
+
+	for i, j := 1, 0; i < n; i = i + 1 {
+		var bit = n >> 1
+
+		for ; j&bit != 0; bit = bit >> 1 {
+			j = j ^ bit
+		}
+
+		j = j ^ bit
+
+		if i < j {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+
+	for size := 2; size <= n; size = size << 1 {
+		var step = cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+
+		for start := 0; start < n; start = start + size {
+			var w complex128 = 1
+
+			for k := 0; k < size/2; k = k + 1 {
+				var even = data[start+k]
+				var odd = data[start+k+size/2] * w
+				data[start+k] = even + odd
+				data[start+k+size/2] = even - odd
+				w = w * step
+			}
+		}
+	}
+}
+
+// PowerSpectrum averages the power of hann windowed frames of the
+// samples into frameSize/2+1 bins from 0 to the nyquist frequency
+func PowerSpectrum(samples []int16, frameSize int) []float64 {
+	var result = make([]float64, frameSize/2+1)
+	var frame = make([]complex128, frameSize)
+	var frameCount = 0
+
+	for start := 0; start == 0 || start+frameSize <= len(samples); start = start + frameSize/2 {
+		for i := range frame {
+			var sample float64 = 0
+
+			if start+i < len(samples) {
+				sample = float64(samples[start+i])
+			}
+
+			var window = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(frameSize))
+			frame[i] = complex(sample*window, 0)
+		}
+
+		fft(frame)
+
+		for i := range result {
+			var magnitude = cmplx.Abs(frame[i])
+			result[i] = result[i] + magnitude*magnitude
+		}
+
+		frameCount = frameCount + 1
+	}
+
+	for i := range result {
+		result[i] = result[i] / float64(frameCount)
+	}
+
+	return result
+}
+
+// the fraction of the power of the spectrum between two frequencies
+func spectrumBandFraction(spectrum []float64, sampleRate float64, from float64, to float64) float64 {
+	var total float64 = 0
+	var band float64 = 0
+	var binWidth = sampleRate / float64(2*(len(spectrum)-1))
+
+	for i, power := range spectrum {
+		var frequency = float64(i) * binWidth
+		total = total + power
+
+		if frequency > from && frequency <= to {
+			band = band + power
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return band / total
+}
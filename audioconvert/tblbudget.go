@@ -0,0 +1,362 @@
+package audioconvert
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/lambertjamesd/sfz2n64/al64"
+)
+
+// the sample rates tried for each sound as a fraction of its current sample rate
+var tblBudgetRatios = []float64{1, 0.875, 0.75, 0.625, 0.5, 0.375, 0.25}
+
+const tblBudgetMinSampleRate = 4000
+const tblBudgetSpectrumSize = 1024
+
+type TblBudgetSound struct {
+	Bank int
+	// -1 for the percussion instrument
+	Instrument    int
+	Sound         int
+	OriginalRate  uint32
+	SampleRate    uint32
+	OriginalSize  int
+	Size          int
+	EstimatedLoss float64
+}
+
+type TblBudgetReport struct {
+	Budget       int
+	OriginalSize int
+	Size         int
+	Sounds       []TblBudgetSound
+}
+
+type tblBudgetCandidate struct {
+	sampleRate uint32
+	size       int
+	// the fraction of the audible energy removed
+	loss float64
+}
+
+type tblBudgetWavetable struct {
+	wavetable  *al64.ALWavetable
+	sampleRate uint32
+	sounds     []*al64.ALSound
+	candidates []tblBudgetCandidate
+	current    int
+}
+
+func alignTbl(size int) int {
+	return (size + 0xf) & ^0xf
+}
+
+func estimateWavetableSize(wavetable *al64.ALWavetable, ratio float64) int {
+	if wavetable.Type == al64.AL_ADPCM_WAVE {
+		var frameCount = len(wavetable.DataFromTable) / 9
+		return alignTbl(9 * int(math.Ceil(float64(frameCount)*ratio)))
+	}
+
+	return alignTbl(2 * int(math.Ceil(float64(len(wavetable.DataFromTable)/2)*ratio)))
+}
+
+// the playback rate of the sound relative to its sample rate for each key it is used for
+func soundPlaybackRatios(sound *al64.ALSound) []float64 {
+	if sound.KeyMap == nil {
+		return []float64{1}
+	}
+
+	var result []float64 = nil
+
+	for key := int(sound.KeyMap.KeyMin); key <= int(sound.KeyMap.KeyMax); key = key + 1 {
+		var cents = float64((key-int(sound.KeyMap.KeyBase))*100 + int(int8(sound.KeyMap.Detune)))
+		result = append(result, math.Pow(2, cents/1200))
+	}
+
+	if len(result) == 0 {
+		return []float64{1}
+	}
+
+	return result
+}
+
+// estimates how much of the sound is lost when the sample rate is
+// lowered. Frequencies that end up above the nyquist frequency of the
+// bank when played at a higher pitch are already inaudible so only the
+// audible part of the removed frequencies counts
+func estimateResampleLoss(spectrum []float64, sampleRate uint32, newRate uint32, bankSampleRate uint32, ratios []float64) float64 {
+	var result float64 = 0
+
+	for _, ratio := range ratios {
+		var audibleLimit = float64(bankSampleRate) / 2 / ratio
+		var lostTo = math.Min(float64(sampleRate)/2, audibleLimit)
+
+		if lostTo > float64(newRate)/2 {
+			result = result + spectrumBandFraction(spectrum, float64(sampleRate), float64(newRate)/2, lostTo)
+		}
+	}
+
+	return result / float64(len(ratios))
+}
+
+func canDetuneSounds(sounds []*al64.ALSound, sampleRate uint32, newRate uint32) bool {
+	for _, sound := range sounds {
+		if sound.KeyMap != nil {
+			_, err := detuneKeyMap(sound.KeyMap, newRate, sampleRate)
+
+			if err != nil {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (entry *tblBudgetWavetable) calculateCandidates(bankSampleRate uint32) {
+	var samples = DecodeWavetable(entry.wavetable, entry.wavetable.DataFromTable, entry.sampleRate)
+	var spectrum = PowerSpectrum(samples, tblBudgetSpectrumSize)
+	var ratios []float64 = nil
+
+	for _, sound := range entry.sounds {
+		ratios = append(ratios, soundPlaybackRatios(sound)...)
+	}
+
+	for _, ratio := range tblBudgetRatios {
+		var newRate = uint32(math.Round(float64(entry.sampleRate) * ratio))
+
+		if ratio != 1 && (newRate < tblBudgetMinSampleRate || !canDetuneSounds(entry.sounds, entry.sampleRate, newRate)) {
+			break
+		}
+
+		entry.candidates = append(entry.candidates, tblBudgetCandidate{
+			sampleRate: newRate,
+			size:       estimateWavetableSize(entry.wavetable, ratio),
+			loss:       estimateResampleLoss(spectrum, entry.sampleRate, newRate, bankSampleRate, ratios),
+		})
+	}
+}
+
+func (entry *tblBudgetWavetable) apply(settings *ResampleSettings) error {
+	var candidate = entry.candidates[entry.current]
+
+	if candidate.sampleRate == entry.sampleRate {
+		return nil
+	}
+
+	resampled, err := ResampleWavetable(entry.wavetable, int(candidate.sampleRate), int(entry.sampleRate), settings)
+
+	if err != nil {
+		return err
+	}
+
+	// the keymap makes up for the lower sample rate
+	resampled.FileSampleRate = entry.sampleRate
+
+	for _, sound := range entry.sounds {
+		// sounds used by more than one instrument are only changed once
+		if sound.Wavetable == resampled {
+			continue
+		}
+
+		if sound.KeyMap != nil {
+			keyMap, err := detuneKeyMap(sound.KeyMap, candidate.sampleRate, entry.sampleRate)
+
+			if err != nil {
+				return err
+			}
+
+			sound.KeyMap = keyMap
+		}
+
+		sound.Wavetable = resampled
+	}
+
+	return nil
+}
+
+// OptimizeTblSize lowers the sample rate of individual sounds until
+// the tbl file fits in budget bytes. Sounds that lose the least
+// audible high frequency content for the bytes saved are lowered first
+func OptimizeTblSize(bankFile *al64.ALBankFile, budget int, settings *ResampleSettings) (*TblBudgetReport, error) {
+	var entries []*tblBudgetWavetable = nil
+	var byWavetable = make(map[*al64.ALWavetable]*tblBudgetWavetable)
+	var bankRates = make(map[*al64.ALWavetable]uint32)
+	var soundRefs = make(map[*al64.ALSound][]TblBudgetSound)
+
+	for bankIndex, bank := range bankFile.BankArray {
+		var instruments = append([]*al64.ALInstrument{bank.Percussion}, bank.InstArray...)
+
+		for instIndex, instrument := range instruments {
+			if instrument == nil {
+				continue
+			}
+
+			for soundIndex, sound := range instrument.SoundArray {
+				if sound == nil || sound.Wavetable == nil {
+					continue
+				}
+
+				var sampleRate = sound.Wavetable.FileSampleRate
+
+				if sampleRate == 0 {
+					sampleRate = bank.SampleRate
+				}
+
+				soundRefs[sound] = append(soundRefs[sound], TblBudgetSound{
+					Bank:          bankIndex,
+					Instrument:    instIndex - 1,
+					Sound:         soundIndex,
+					OriginalRate:  sampleRate,
+					SampleRate:    sampleRate,
+					OriginalSize:  alignTbl(len(sound.Wavetable.DataFromTable)),
+					Size:          alignTbl(len(sound.Wavetable.DataFromTable)),
+					EstimatedLoss: 0,
+				})
+
+				entry, ok := byWavetable[sound.Wavetable]
+
+				if !ok {
+					entry = &tblBudgetWavetable{
+						wavetable:  sound.Wavetable,
+						sampleRate: sampleRate,
+						sounds:     nil,
+						candidates: nil,
+						current:    0,
+					}
+					byWavetable[sound.Wavetable] = entry
+					bankRates[sound.Wavetable] = bank.SampleRate
+					entries = append(entries, entry)
+				} else if bankRates[sound.Wavetable] != bank.SampleRate {
+					// wavetables shared by banks with different sample rates are left alone
+					entry.sampleRate = 0
+				}
+
+				entry.sounds = append(entry.sounds, sound)
+			}
+		}
+	}
+
+	var report = &TblBudgetReport{
+		Budget:       budget,
+		OriginalSize: len(bankFile.LayoutTbl(nil)),
+		Size:         0,
+		Sounds:       nil,
+	}
+
+	var size = 0
+
+	for _, entry := range entries {
+		if entry.sampleRate != 0 && len(entry.wavetable.DataFromTable) > 0 {
+			entry.calculateCandidates(bankRates[entry.wavetable])
+		}
+
+		if len(entry.candidates) == 0 {
+			entry.candidates = []tblBudgetCandidate{tblBudgetCandidate{
+				sampleRate: entry.sampleRate,
+				size:       alignTbl(len(entry.wavetable.DataFromTable)),
+				loss:       0,
+			}}
+		}
+
+		size = size + entry.candidates[0].size
+	}
+
+	// lower the sample rate of the sound that loses the least quality
+	// for the number of bytes saved until the budget is met
+	for size > budget {
+		var best *tblBudgetWavetable = nil
+		var bestCost float64 = 0
+
+		for _, entry := range entries {
+			if entry.current+1 >= len(entry.candidates) {
+				continue
+			}
+
+			var current = entry.candidates[entry.current]
+			var next = entry.candidates[entry.current+1]
+			var saved = current.size - next.size
+
+			if saved <= 0 {
+				continue
+			}
+
+			var cost = (next.loss - current.loss) / float64(saved)
+
+			if best == nil || cost < bestCost {
+				best = entry
+				bestCost = cost
+			}
+		}
+
+		if best == nil {
+			break
+		}
+
+		size = size - best.candidates[best.current].size + best.candidates[best.current+1].size
+		best.current = best.current + 1
+	}
+
+	for _, entry := range entries {
+		var candidate = entry.candidates[entry.current]
+		err := entry.apply(settings)
+
+		if err != nil {
+			var location = soundRefs[entry.sounds[0]][0]
+			return nil, &SoundError{soundLocation(location.Bank, location.Instrument, location.Sound), err}
+		}
+
+		for _, sound := range entry.sounds {
+			var refs = soundRefs[sound]
+			delete(soundRefs, sound)
+
+			for _, ref := range refs {
+				ref.SampleRate = candidate.sampleRate
+				ref.Size = alignTbl(len(sound.Wavetable.DataFromTable))
+				ref.EstimatedLoss = candidate.loss
+				report.Sounds = append(report.Sounds, ref)
+			}
+		}
+	}
+
+	sort.SliceStable(report.Sounds, func(i, j int) bool {
+		var a = report.Sounds[i]
+		var b = report.Sounds[j]
+
+		if a.Bank != b.Bank {
+			return a.Bank < b.Bank
+		} else if a.Instrument != b.Instrument {
+			return a.Instrument < b.Instrument
+		}
+
+		return a.Sound < b.Sound
+	})
+
+	report.Size = len(bankFile.LayoutTbl(nil))
+
+	if report.Size > budget {
+		return report, errors.New(fmt.Sprintf("Could only reduce the tbl size to %d bytes which is larger than the budget of %d bytes", report.Size, budget))
+	}
+
+	return report, nil
+}
+
+func (report *TblBudgetReport) Write(out io.Writer) {
+	fmt.Fprintf(out, "tbl size %d -> %d bytes, budget %d bytes\n", report.OriginalSize, report.Size, report.Budget)
+
+	for _, sound := range report.Sounds {
+		fmt.Fprintf(
+			out,
+			"%s: %d -> %d Hz, %d -> %d bytes, estimated loss %.2f%%\n",
+			soundLocation(sound.Bank, sound.Instrument, sound.Sound),
+			sound.OriginalRate,
+			sound.SampleRate,
+			sound.OriginalSize,
+			sound.Size,
+			sound.EstimatedLoss*100,
+		)
+	}
+}
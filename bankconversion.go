@@ -6,22 +6,26 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/lambertjamesd/sfz2n64/al64"
 	"github.com/lambertjamesd/sfz2n64/audioconvert"
 	"github.com/lambertjamesd/sfz2n64/convert"
+	"github.com/lambertjamesd/sfz2n64/dls"
+	"github.com/lambertjamesd/sfz2n64/midi"
+	"github.com/lambertjamesd/sfz2n64/sf2"
 	"github.com/lambertjamesd/sfz2n64/sfz"
 )
 
 func isBankFile(ext string) bool {
-	return ext == ".sfz" || ext == ".ctl" || ext == ".ins"
+	return ext == ".sfz" || ext == ".ctl" || ext == ".ins" || ext == ".sf2" || ext == ".dls"
 }
 
 func isRomFile(ext string) bool {
 	return ext == ".n64" || ext == ".z64" || ext == ".v64"
 }
 
-func parseInputBank(input string) (*al64.ALBankFile, []byte, bool, error) {
+func parseInputBank(input string, pcmSettings *audioconvert.PCMConversionSettings) (*al64.ALBankFile, []byte, bool, error) {
 	var ext = filepath.Ext(input)
 
 	var bankFile *al64.ALBankFile
@@ -37,7 +41,51 @@ func parseInputBank(input string) (*al64.ALBankFile, []byte, bool, error) {
 			return nil, nil, false, err
 		}
 
-		bankFile, err = convert.Sfz2N64(sfzFile, input)
+		bankFile, err = convert.Sfz2N64(sfzFile, input, pcmSettings)
+
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		tblData = audioconvert.BuildTbl(bankFile)
+	} else if ext == ".sf2" {
+		file, err := os.Open(input)
+
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		defer file.Close()
+
+		sf2File, err := sf2.Parse(file)
+
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		bankFile, err = convert.Sf2N64(sf2File)
+
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		tblData = audioconvert.BuildTbl(bankFile)
+	} else if ext == ".dls" {
+		file, err := os.Open(input)
+
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		defer file.Close()
+
+		dlsFile, err := dls.Parse(file)
+
+		if err != nil {
+			return nil, nil, false, err
+		}
+
+		bankFile, err = convert.Dls2N64(dlsFile)
 
 		if err != nil {
 			return nil, nil, false, err
@@ -64,6 +112,8 @@ func parseInputBank(input string) (*al64.ALBankFile, []byte, bool, error) {
 		if err != nil {
 			return nil, nil, false, err
 		}
+
+		al64.WriteTlbIntoBank(bankFile, tblData)
 	} else if ext == ".ins" {
 		file, err := ioutil.ReadFile(input)
 
@@ -72,7 +122,7 @@ func parseInputBank(input string) (*al64.ALBankFile, []byte, bool, error) {
 		}
 
 		instFile, parseErrors := al64.ParseIns(string(file), input, func(waveFilename string) (*al64.ALWavetable, error) {
-			sound, err := audioconvert.ReadWavetable(waveFilename)
+			sound, err := audioconvert.ReadWavetable(waveFilename, pcmSettings)
 
 			if err != nil {
 				return nil, err
@@ -102,6 +152,10 @@ func writeBank(input string, output string, bankFile *al64.ALBankFile, tblData [
 
 	if outExt == ".sfz" {
 		return convert.WriteSfzFile(bankFile, tblData, output)
+	} else if outExt == ".sf2" {
+		return convert.WriteSf2File(bankFile, tblData, output)
+	} else if outExt == ".dls" {
+		return convert.WriteDlsFile(bankFile, tblData, output)
 	} else if outExt == ".ctl" {
 		return convert.WriteCtlFile(output, bankFile)
 	} else if outExt == ".ins" {
@@ -120,8 +174,65 @@ func writeBank(input string, output string, bankFile *al64.ALBankFile, tblData [
 	}
 }
 
+func writeProgramChanges(bankIndex int, programs map[int]int) {
+	var moved []int = nil
+
+	for program := range programs {
+		moved = append(moved, program)
+	}
+
+	sort.Ints(moved)
+
+	for _, program := range moved {
+		fmt.Printf("bank %d: moved program %d to %d\n", bankIndex, program, programs[program])
+	}
+}
+
+// songs are written next to the output with the
+// name of the output added to the start of the name
+func writeBankSequences(output string, bankMapping [][]*midi.Midi, songFilenames [][]string) error {
+	var outExt = filepath.Ext(output)
+	var prefix = output[0:len(output)-len(outExt)] + "_"
+	var written = make(map[string]string)
+
+	for bankIndex, songs := range bankMapping {
+		for songIndex, song := range songs {
+			var source = songFilenames[bankIndex][songIndex]
+			var songOutput = prefix + filepath.Base(source)
+
+			previous, ok := written[songOutput]
+
+			if ok && previous != source {
+				return errors.New(fmt.Sprintf("%s and %s would both be written to %s", previous, source, songOutput))
+			}
+
+			written[songOutput] = source
+
+			outFile, err := os.OpenFile(songOutput, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0664)
+
+			if err != nil {
+				return err
+			}
+
+			err = midi.WriteMidi(outFile, song)
+			outFile.Close()
+
+			if err != nil {
+				return sequenceFileError(songOutput, err)
+			}
+
+			fmt.Printf("Wrote %s\n", songOutput)
+		}
+	}
+
+	return nil
+}
+
 func convertBank(input string, output string, args *SFZConvertArgs) {
-	bankFile, tblData, isSingleInstrument, err := parseInputBank(input)
+	reportCodebookSearch(args.ResampleSettings.CompressionSettings, os.Stdout)
+	reportCodebookSearch(args.SharedCodebookSettings, os.Stdout)
+
+	bankFile, tblData, isSingleInstrument, err := parseInputBank(input, args.PCMConversionSettings)
 
 	if err != nil {
 		fmt.Println(err)
@@ -129,7 +240,7 @@ func convertBank(input string, output string, args *SFZConvertArgs) {
 	}
 
 	if args.BankSequenceMapping != "" {
-		bankMapping, err := convert.ParseBankUsageFile(args.BankSequenceMapping)
+		bankMapping, songFilenames, err := convert.ParseBankUsageFile(args.BankSequenceMapping)
 
 		if err != nil {
 			fmt.Println(err)
@@ -137,6 +248,19 @@ func convertBank(input string, output string, args *SFZConvertArgs) {
 		}
 
 		for i := 0; i < len(bankMapping) && i < len(bankFile.BankArray); i++ {
+			if args.RemapPrograms != "" {
+				for songIndex, song := range bankMapping[i] {
+					remapped, err := remapSequencePrograms(song, bankFile.BankArray[i], args.RemapPrograms, os.Stdout)
+
+					if err != nil {
+						fmt.Println(err)
+						os.Exit(1)
+					}
+
+					bankMapping[i][songIndex] = remapped
+				}
+			}
+
 			bank, err := convert.RemoveUnusedSounds(bankFile.BankArray[i], bankMapping[i])
 
 			if err != nil {
@@ -145,11 +269,84 @@ func convertBank(input string, output string, args *SFZConvertArgs) {
 			} else {
 				bankFile.BankArray[i] = bank
 			}
+
+			if args.CompactInstruments {
+				bank, programs := convert.CompactInstruments(bankFile.BankArray[i])
+				bankFile.BankArray[i] = bank
+
+				for songIndex, song := range bankMapping[i] {
+					bankMapping[i][songIndex] = convert.ReplacePrograms(song, programs)
+				}
+
+				writeProgramChanges(i, programs)
+			}
+		}
+
+		if args.RemapPrograms != "" || args.CompactInstruments {
+			err = writeBankSequences(output, bankMapping, songFilenames)
+
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+	} else if args.CompactInstruments {
+		for i, bank := range bankFile.BankArray {
+			bank, programs := convert.CompactInstruments(bank)
+			bankFile.BankArray[i] = bank
+			writeProgramChanges(i, programs)
+		}
+	}
+
+	// changing the sample rate already resamples every sound from its own sample rate
+	if args.TargetSampleRate == 0 || args.SampleRateStrategy != audioconvert.SAMPLE_RATE_RESAMPLE {
+		changed, err := audioconvert.NormalizeBankSampleRates(bankFile, args.SampleRateStrategy, &args.ResampleSettings)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if changed {
+			tblData = audioconvert.BuildTbl(bankFile)
 		}
 	}
 
 	if args.TargetSampleRate != 0 {
-		bankFile = audioconvert.ResampleBankFile(bankFile, args.TargetSampleRate)
+		bankFile, err = audioconvert.ResampleBankFile(bankFile, args.TargetSampleRate, &args.ResampleSettings)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		tblData = bankFile.LayoutTbl(nil)
+	}
+
+	if args.TblBudget != 0 {
+		report, err := audioconvert.OptimizeTblSize(bankFile, args.TblBudget, &args.ResampleSettings)
+
+		if report != nil {
+			report.Write(os.Stdout)
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		tblData = bankFile.LayoutTbl(nil)
+	}
+
+	if args.SharedCodebooks != 0 {
+		report, err := audioconvert.ShareCodebooks(bankFile, args.SharedCodebooks, args.SharedCodebookSettings)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		report.Write(os.Stdout)
 		tblData = bankFile.LayoutTbl(nil)
 	}
 
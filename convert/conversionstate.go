@@ -3,6 +3,8 @@ package convert
 import (
 	"fmt"
 	"os"
+
+	"github.com/lambertjamesd/sfz2n64/al64"
 )
 
 type writeIntoIns func(state *insConversionState, source interface{}, output *os.File) (string, error)
@@ -16,6 +18,8 @@ type insConversionState struct {
 	tblData         []byte
 	instrumentNames []string
 	skipBank        bool
+	// the keymap of the sound being written, stored in wav files
+	keyMap *al64.ALKeyMap
 }
 
 func (state *insConversionState) getInstrumentName(index int) string {
@@ -0,0 +1,317 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/lambertjamesd/sfz2n64/al64"
+	"github.com/lambertjamesd/sfz2n64/audioconvert"
+	"github.com/lambertjamesd/sfz2n64/dls"
+	"github.com/lambertjamesd/sfz2n64/wav"
+)
+
+type dlsWavetable struct {
+	wave       uint32
+	loopStart  uint32
+	loopLength uint32
+}
+
+type dlsConversionState struct {
+	input      *dls.Dls
+	wavetables map[dlsWavetable]*al64.ALWavetable
+}
+
+// converts the wave data to big endian 16 bit samples
+func dlsWaveData(wave *dls.Wave) ([]byte, error) {
+	if wave.Format.Format != wav.FORMAT_PCM {
+		return nil, errors.New(fmt.Sprintf("Wave %s should be pcm", wave.Name))
+	}
+
+	if wave.Format.NChannels != 1 {
+		return nil, errors.New(fmt.Sprintf("Wave %s should have 1 channel", wave.Name))
+	}
+
+	if wave.Format.BitsPerSample == 16 {
+		var result = make([]byte, len(wave.Data)&^1)
+
+		for index := 0; index+1 < len(wave.Data); index = index + 2 {
+			result[index], result[index+1] = wave.Data[index+1], wave.Data[index]
+		}
+
+		return result, nil
+	} else if wave.Format.BitsPerSample == 8 {
+		var result = make([]byte, len(wave.Data)*2)
+
+		// 8 bit samples are unsigned
+		for index, sample := range wave.Data {
+			result[index*2] = sample - 128
+			result[index*2+1] = 0
+		}
+
+		return result, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Wave %s should have 8 or 16 bits per sample", wave.Name))
+}
+
+func dlsTimeToSeconds(scale int32) float64 {
+	if scale == dls.CONN_TIME_ZERO {
+		return 0
+	}
+
+	return math.Pow(2, float64(scale)/(1200*dls.CONN_SCALE))
+}
+
+func dlsParseEnvelope(connections []dls.Connection) *al64.ALEnvelope {
+	var attackTime float64 = 0
+	var decayTime float64 = 0
+	var releaseTime float64 = 0
+	var sustainLevel float64 = 100
+
+	for _, connection := range connections {
+		if connection.Source != dls.CONN_SRC_NONE || connection.Control != dls.CONN_SRC_NONE {
+			continue
+		}
+
+		switch connection.Destination {
+		case dls.CONN_DST_EG1_ATTACKTIME:
+			attackTime = dlsTimeToSeconds(connection.Scale)
+		case dls.CONN_DST_EG1_DECAYTIME:
+			decayTime = dlsTimeToSeconds(connection.Scale)
+		case dls.CONN_DST_EG1_RELEASETIME:
+			releaseTime = dlsTimeToSeconds(connection.Scale)
+		case dls.CONN_DST_EG1_SUSTAINLEVEL:
+			// sustain is measured in tenths of a percent
+			sustainLevel = float64(connection.Scale) / dls.CONN_SCALE / 10
+		}
+	}
+
+	return createEnvelope(attackTime, decayTime, releaseTime, sustainLevel)
+}
+
+func dlsParsePan(connections []dls.Connection) uint8 {
+	for _, connection := range connections {
+		if connection.Source == dls.CONN_SRC_NONE &&
+			connection.Control == dls.CONN_SRC_NONE &&
+			connection.Destination == dls.CONN_DST_PAN {
+			// pan is measured in tenths of a percent from -500 to 500
+			var pan = clampInt(int(math.Round(float64(connection.Scale)/dls.CONN_SCALE)), -500, 500)
+			return uint8(((pan+500)*127 + 500) / 1000)
+		}
+	}
+
+	return 64
+}
+
+func dlsClampKey(value uint16) uint8 {
+	if value > 127 {
+		return 127
+	}
+
+	return uint8(value)
+}
+
+func (state *dlsConversionState) getWavetable(waveIndex uint32, waveSample *dls.WaveSample) (*al64.ALWavetable, error) {
+	var key = dlsWavetable{wave: waveIndex}
+
+	if len(waveSample.Loops) > 0 {
+		key.loopStart = waveSample.Loops[0].Start
+		key.loopLength = waveSample.Loops[0].Length
+	}
+
+	existing, ok := state.wavetables[key]
+
+	if ok {
+		return existing, nil
+	}
+
+	var wave = state.input.Waves[waveIndex]
+
+	data, err := dlsWaveData(wave)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result = &al64.ALWavetable{
+		Base:           0,
+		Len:            int32(len(data)),
+		Type:           al64.AL_RAW16_WAVE,
+		AdpcWave:       al64.ALADPCMWaveInfo{Loop: nil, Book: nil},
+		RawWave:        al64.ALRAWWaveInfo{Loop: nil},
+		DataFromTable:  data,
+		FileSampleRate: wave.Format.SampleRate,
+	}
+
+	var sampleCount = uint32(len(data) / 2)
+
+	if key.loopLength > 0 && key.loopStart < sampleCount {
+		var end = key.loopStart + key.loopLength
+
+		if end > sampleCount {
+			end = sampleCount
+		}
+
+		result.RawWave.Loop = &al64.ALRawLoop{
+			Start: key.loopStart,
+			End:   end,
+			Count: ^uint32(0),
+		}
+	}
+
+	state.wavetables[key] = result
+
+	return result, nil
+}
+
+func (state *dlsConversionState) parseSound(instrument *dls.Instrument, region *dls.Region) (*al64.ALSound, error) {
+	if int(region.WaveLink.TableIndex) >= len(state.input.Waves) {
+		return nil, errors.New(fmt.Sprintf("Instrument %s uses an invalid wave", instrument.Name))
+	}
+
+	var waveSample = region.WaveSample
+
+	if waveSample == nil {
+		waveSample = state.input.Waves[region.WaveLink.TableIndex].WaveSample
+	}
+
+	if waveSample == nil {
+		waveSample = &dls.WaveSample{UnityNote: 60}
+	}
+
+	wavetable, err := state.getWavetable(region.WaveLink.TableIndex, waveSample)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var keyMap al64.ALKeyMap
+
+	keyMap.KeyMin = dlsClampKey(region.KeyLow)
+	keyMap.KeyMax = dlsClampKey(region.KeyHigh)
+	keyMap.VelocityMin = dlsClampKey(region.VelocityLow)
+	keyMap.VelocityMax = dlsClampKey(region.VelocityHigh)
+	keyMap.KeyBase = dlsClampKey(waveSample.UnityNote)
+	setKeyMapDetune(&keyMap, int64(waveSample.FineTune))
+
+	var articulation = region.Articulation
+
+	if articulation == nil {
+		articulation = instrument.Articulation
+	}
+
+	return &al64.ALSound{
+		Envelope:     dlsParseEnvelope(articulation),
+		KeyMap:       &keyMap,
+		Wavetable:    wavetable,
+		SamplePan:    dlsParsePan(articulation),
+		SampleVolume: volumeFromDecibels(float64(waveSample.Attenuation) / (10 * dls.CONN_SCALE)),
+	}, nil
+}
+
+func (state *dlsConversionState) parseInstrument(instrument *dls.Instrument) (*al64.ALInstrument, error) {
+	var result al64.ALInstrument
+
+	result.Volume = 127
+	result.Pan = 64
+	result.BendRange = 200
+
+	for _, region := range instrument.Regions {
+		sound, err := state.parseSound(instrument, region)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.SoundArray = append(result.SoundArray, sound)
+	}
+
+	return &result, nil
+}
+
+// Dls2N64 creates an ALBank for each bank number used by a melodic
+// instrument. The drum instrument with the lowest program number is
+// used as the percussion instrument of every bank
+func Dls2N64(input *dls.Dls) (*al64.ALBankFile, error) {
+	var state = dlsConversionState{
+		input:      input,
+		wavetables: make(map[dlsWavetable]*al64.ALWavetable),
+	}
+
+	var result al64.ALBankFile
+	var banks = make(map[uint16]*al64.ALBank)
+	var bankNumbers []int = nil
+	var percussion *al64.ALInstrument = nil
+	var percussionProgram = -1
+
+	for _, instrument := range input.Instruments {
+		if instrument.IsDrums() {
+			if percussionProgram != -1 && int(instrument.Program) > percussionProgram {
+				continue
+			}
+
+			converted, err := state.parseInstrument(instrument)
+
+			if err != nil {
+				return nil, err
+			}
+
+			percussion = converted
+			percussionProgram = int(instrument.Program)
+			continue
+		}
+
+		if instrument.Program > 127 {
+			return nil, errors.New(fmt.Sprintf("Instrument %s has an invalid program number %d", instrument.Name, instrument.Program))
+		}
+
+		var bankNumber = instrument.BankNumber()
+		bank, ok := banks[bankNumber]
+
+		if !ok {
+			bank = &al64.ALBank{SampleRate: 0, Percussion: nil, InstArray: nil}
+			banks[bankNumber] = bank
+			bankNumbers = append(bankNumbers, int(bankNumber))
+		}
+
+		converted, err := state.parseInstrument(instrument)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for int(instrument.Program) >= len(bank.InstArray) {
+			bank.InstArray = append(bank.InstArray, nil)
+		}
+
+		bank.InstArray[instrument.Program] = converted
+	}
+
+	sort.Ints(bankNumbers)
+
+	for _, bankNumber := range bankNumbers {
+		result.BankArray = append(result.BankArray, banks[uint16(bankNumber)])
+	}
+
+	if percussion != nil {
+		if len(result.BankArray) == 0 {
+			result.BankArray = append(result.BankArray, &al64.ALBank{SampleRate: 0, Percussion: nil, InstArray: nil})
+		}
+
+		for _, bank := range result.BankArray {
+			bank.Percussion = percussion
+		}
+	}
+
+	if len(result.BankArray) == 0 {
+		return nil, errors.New("dls file does not have any instruments")
+	}
+
+	for _, bank := range result.BankArray {
+		bank.SampleRate = audioconvert.ChooseBankSampleRate(bank)
+	}
+
+	return &result, nil
+}
@@ -162,6 +162,28 @@ var MIDINames []string = []string{
 	"Gunshot",
 }
 
+// General MIDI groups instruments into families of 8 programs
+const MIDIFamilySize = 8
+
+var MIDIFamilyNames = []string{
+	"Piano",
+	"Chromatic Percussion",
+	"Organ",
+	"Guitar",
+	"Bass",
+	"Strings",
+	"Ensemble",
+	"Brass",
+	"Reed",
+	"Pipe",
+	"Synth Lead",
+	"Synth Pad",
+	"Synth Effects",
+	"Ethnic",
+	"Percussive",
+	"Sound Effects",
+}
+
 var PercussionNames = []string{
 	"Percussion 01",
 	"Percussion 02",
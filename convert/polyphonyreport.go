@@ -0,0 +1,266 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+type PolyphonyNote struct {
+	Tick     uint32 `json:"tick"`
+	Channel  int    `json:"channel"`
+	Program  int    `json:"program"`
+	Key      int    `json:"key"`
+	Velocity int    `json:"velocity"`
+}
+
+type PolyphonyPoint struct {
+	Tick         uint32 `json:"tick"`
+	Microseconds int    `json:"microseconds"`
+	Voices       int    `json:"voices"`
+}
+
+type PolyphonyPeak struct {
+	Tick         uint32          `json:"tick"`
+	Microseconds int             `json:"microseconds"`
+	Voices       int             `json:"voices"`
+	Notes        []PolyphonyNote `json:"notes"`
+}
+
+type ChannelPolyphony struct {
+	Channel   int `json:"channel"`
+	MaxVoices int `json:"maxVoices"`
+}
+
+// program is -1 for percussion
+type InstrumentPolyphony struct {
+	Program   int `json:"program"`
+	MaxVoices int `json:"maxVoices"`
+}
+
+type PolyphonyReport struct {
+	EndTick              uint32                `json:"endTick"`
+	DurationMicroseconds int                   `json:"durationMicroseconds"`
+	MaxVoices            int                   `json:"maxVoices"`
+	Channels             []ChannelPolyphony    `json:"channels"`
+	Instruments          []InstrumentPolyphony `json:"instruments"`
+	// the notes playing each time the voice count reaches MaxVoices
+	Peaks []PolyphonyPeak `json:"peaks"`
+	// notes that never stop on their own and are still playing at the end
+	SustainedNotes []PolyphonyNote `json:"sustainedNotes"`
+	// the voice count each time it changes
+	Timeline []PolyphonyPoint `json:"timeline"`
+	Changes  []VoiceChange    `json:"changes"`
+
+	channelVoices    map[int]int
+	instrumentVoices map[int]int
+}
+
+func newPolyphonyReport() *PolyphonyReport {
+	return &PolyphonyReport{
+		channelVoices:    make(map[int]int),
+		instrumentVoices: make(map[int]int),
+	}
+}
+
+func polyphonyNote(note *activeNote) PolyphonyNote {
+	return PolyphonyNote{
+		Tick:     note.startTick,
+		Channel:  int(note.channel),
+		Program:  note.program,
+		Key:      int(note.key.node),
+		Velocity: int(note.velocity),
+	}
+}
+
+func sortedPolyphonyNotes(notes map[noteKey]*activeNote, filter func(note *activeNote) bool) []PolyphonyNote {
+	var result []PolyphonyNote = nil
+
+	for _, note := range notes {
+		if filter(note) {
+			result = append(result, polyphonyNote(note))
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Tick != result[j].Tick {
+			return result[i].Tick < result[j].Tick
+		} else if result[i].Channel != result[j].Channel {
+			return result[i].Channel < result[j].Channel
+		}
+
+		return result[i].Key < result[j].Key
+	})
+
+	return result
+}
+
+// record is called any time a note is added or removed
+func (report *PolyphonyReport) record(tick uint32, microseconds int, notes map[noteKey]*activeNote) {
+	var previous = 0
+
+	if len(report.Timeline) > 0 {
+		previous = report.Timeline[len(report.Timeline)-1].Voices
+	}
+
+	var voices = len(notes)
+
+	if voices == previous {
+		return
+	}
+
+	var last = len(report.Timeline) - 1
+
+	// notes that change at the same time share a single point
+	if last >= 0 && report.Timeline[last].Microseconds == microseconds {
+		report.Timeline[last].Voices = voices
+	} else {
+		report.Timeline = append(report.Timeline, PolyphonyPoint{tick, microseconds, voices})
+	}
+
+	var channelVoices = make(map[int]int)
+	var instrumentVoices = make(map[int]int)
+
+	for _, note := range notes {
+		channelVoices[int(note.channel)]++
+		instrumentVoices[note.program]++
+	}
+
+	for channel, count := range channelVoices {
+		if count > report.channelVoices[channel] {
+			report.channelVoices[channel] = count
+		}
+	}
+
+	for program, count := range instrumentVoices {
+		if count > report.instrumentVoices[program] {
+			report.instrumentVoices[program] = count
+		}
+	}
+
+	if voices > report.MaxVoices {
+		report.MaxVoices = voices
+		report.Peaks = nil
+	}
+
+	if voices == report.MaxVoices && voices > previous {
+		report.Peaks = append(report.Peaks, PolyphonyPeak{
+			Tick:         tick,
+			Microseconds: microseconds,
+			Voices:       voices,
+			Notes: sortedPolyphonyNotes(notes, func(note *activeNote) bool {
+				return true
+			}),
+		})
+	}
+}
+
+func (report *PolyphonyReport) finish(tick uint32, microseconds int, notes map[noteKey]*activeNote) {
+	report.EndTick = tick
+	report.DurationMicroseconds = microseconds
+
+	report.SustainedNotes = sortedPolyphonyNotes(notes, func(note *activeNote) bool {
+		return note.untilMicroseconds == noNoteEnd
+	})
+
+	report.Channels = nil
+
+	for channel, count := range report.channelVoices {
+		report.Channels = append(report.Channels, ChannelPolyphony{channel, count})
+	}
+
+	sort.Slice(report.Channels, func(i, j int) bool {
+		return report.Channels[i].Channel < report.Channels[j].Channel
+	})
+
+	report.Instruments = nil
+
+	for program, count := range report.instrumentVoices {
+		report.Instruments = append(report.Instruments, InstrumentPolyphony{program, count})
+	}
+
+	sort.Slice(report.Instruments, func(i, j int) bool {
+		return report.Instruments[i].Program < report.Instruments[j].Program
+	})
+}
+
+func programName(program int) string {
+	if program == percussionChannel {
+		return "percussion"
+	} else if program >= 0 && program < len(MIDINames) {
+		return fmt.Sprintf("program %d (%s)", program, MIDINames[program])
+	}
+
+	return fmt.Sprintf("program %d", program)
+}
+
+func secondsString(microseconds int) string {
+	return fmt.Sprintf("%.3fs", float64(microseconds)/1000000)
+}
+
+func (note *PolyphonyNote) String() string {
+	return fmt.Sprintf(
+		"channel %d %s note %d velocity %d started at tick %d",
+		note.Channel,
+		programName(note.Program),
+		note.Key,
+		note.Velocity,
+		note.Tick,
+	)
+}
+
+func (report *PolyphonyReport) Write(out io.Writer) {
+	fmt.Fprintf(out, "song length %s, %d ticks\n", secondsString(report.DurationMicroseconds), report.EndTick)
+	fmt.Fprintf(out, "max voices %d\n", report.MaxVoices)
+
+	fmt.Fprintln(out, "max voices per channel")
+
+	for _, channel := range report.Channels {
+		fmt.Fprintf(out, "  channel %d: %d\n", channel.Channel, channel.MaxVoices)
+	}
+
+	fmt.Fprintln(out, "max voices per instrument")
+
+	for _, instrument := range report.Instruments {
+		fmt.Fprintf(out, "  %s: %d\n", programName(instrument.Program), instrument.MaxVoices)
+	}
+
+	for _, peak := range report.Peaks {
+		fmt.Fprintf(out, "%d voices at tick %d (%s)\n", peak.Voices, peak.Tick, secondsString(peak.Microseconds))
+
+		for _, note := range peak.Notes {
+			fmt.Fprintf(out, "  %s\n", note.String())
+		}
+	}
+
+	if len(report.SustainedNotes) > 0 {
+		fmt.Fprintln(out, "notes that never stop")
+
+		for _, note := range report.SustainedNotes {
+			fmt.Fprintf(out, "  %s\n", note.String())
+		}
+	}
+
+	fmt.Fprintln(out, "voice timeline")
+
+	for _, point := range report.Timeline {
+		fmt.Fprintf(out, "  tick %d (%s): %d\n", point.Tick, secondsString(point.Microseconds), point.Voices)
+	}
+
+	for _, change := range report.Changes {
+		fmt.Fprintln(out, change.String())
+	}
+}
+
+func (report *PolyphonyReport) WriteJSON(out io.Writer) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(append(data, '\n'))
+
+	return err
+}
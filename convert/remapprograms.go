@@ -0,0 +1,332 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lambertjamesd/sfz2n64/al64"
+	"github.com/lambertjamesd/sfz2n64/midi"
+)
+
+type ProgramSubstitution struct {
+	From int
+	To   int
+	// true if the substitution came from a mapping file
+	Mapped bool
+	// the number of notes played using the program
+	NoteCount int
+	// the number of notes the new instrument has no sound for
+	MissingNotes int
+}
+
+type ProgramRemapReport struct {
+	Substitutions []ProgramSubstitution
+}
+
+type programUsage struct {
+	notes []*midi.MidiEvent
+}
+
+func parseProgram(value string) (int, error) {
+	var trimmed = strings.TrimSpace(value)
+
+	asInt, err := strconv.ParseInt(trimmed, 10, 32)
+
+	if err == nil {
+		if asInt < 0 || asInt > 127 {
+			return 0, errors.New(fmt.Sprintf("Program %d should be between 0 and 127", asInt))
+		}
+
+		return int(asInt), nil
+	}
+
+	for index, name := range MIDINames {
+		if strings.EqualFold(name, trimmed) {
+			return index, nil
+		}
+	}
+
+	return 0, errors.New(fmt.Sprintf("'%s' is not a program number or a General MIDI instrument name", trimmed))
+}
+
+// ParseProgramMapping reads a file where each line is in the form from = to.
+// Programs can be a number from 0 to 127 or a General MIDI instrument name.
+// Anything after a # is ignored
+func ParseProgramMapping(filename string) (map[int]int, error) {
+	textData, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result = make(map[int]int)
+
+	for lineIndex, line := range strings.Split(string(textData), "\n") {
+		var commentStart = strings.Index(line, "#")
+
+		if commentStart != -1 {
+			line = line[0:commentStart]
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var parts = strings.Split(line, "=")
+
+		if len(parts) != 2 {
+			return nil, errors.New(fmt.Sprintf("%s:%d: expected from = to", filename, lineIndex+1))
+		}
+
+		from, err := parseProgram(parts[0])
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s:%d: %s", filename, lineIndex+1, err.Error()))
+		}
+
+		to, err := parseProgram(parts[1])
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s:%d: %s", filename, lineIndex+1, err.Error()))
+		}
+
+		result[from] = to
+	}
+
+	return result, nil
+}
+
+func hasInstrument(bank *al64.ALBank, program int) bool {
+	return program >= 0 && program < len(bank.InstArray) && bank.InstArray[program] != nil
+}
+
+func countMissingNotes(bank *al64.ALBank, program int, usage *programUsage) int {
+	var result = 0
+
+	for _, note := range usage.notes {
+		_, sound := getUsedInstrument(bank, program, note.FirstParam, note.SecondParam)
+
+		if sound == nil {
+			result = result + 1
+		}
+	}
+
+	return result
+}
+
+func absInt(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
+
+// picks an instrument in the same General MIDI family if possible, then
+// the instrument with a sound for the most notes, then the closest program
+func findSubstituteProgram(bank *al64.ALBank, program int, usage *programUsage) (int, int) {
+	var best = -1
+	var bestMissing = 0
+	var bestOtherFamily = false
+
+	for candidate := range bank.InstArray {
+		if !hasInstrument(bank, candidate) {
+			continue
+		}
+
+		var otherFamily = candidate/MIDIFamilySize != program/MIDIFamilySize
+		var missing = countMissingNotes(bank, candidate, usage)
+
+		var isBetter = best == -1
+
+		if !isBetter && otherFamily != bestOtherFamily {
+			isBetter = !otherFamily
+		} else if !isBetter && missing != bestMissing {
+			isBetter = missing < bestMissing
+		} else if !isBetter {
+			isBetter = absInt(candidate-program) < absInt(best-program)
+		}
+
+		if isBetter {
+			best = candidate
+			bestMissing = missing
+			bestOtherFamily = otherFamily
+		}
+	}
+
+	return best, bestMissing
+}
+
+// RemapPrograms changes program change events so every program used by the
+// song is an instrument in the bank. Programs in mapping are always replaced
+// and any other program that isn't in the bank is replaced automatically
+func RemapPrograms(midiFile *midi.Midi, bank *al64.ALBank, mapping map[int]int) (*midi.Midi, *ProgramRemapReport, error) {
+	var usage = make(map[int]*programUsage)
+	var programs [16]int
+
+	programs[9] = percussionChannel
+
+	for _, event := range midi.MergeTracks(midiFile).Tracks[0].Events {
+		if event.EventType == midi.ProgramChange {
+			programs[event.Channel] = int(event.FirstParam)
+
+			if usage[programs[event.Channel]] == nil {
+				usage[programs[event.Channel]] = &programUsage{}
+			}
+		} else if event.EventType == midi.MidiOn && !midi.IsNoteOff(event) {
+			var program = programs[event.Channel]
+
+			if program == percussionChannel {
+				continue
+			}
+
+			if usage[program] == nil {
+				usage[program] = &programUsage{}
+			}
+
+			usage[program].notes = append(usage[program].notes, event)
+		}
+	}
+
+	var usedPrograms []int = nil
+
+	for program := range usage {
+		usedPrograms = append(usedPrograms, program)
+	}
+
+	sort.Ints(usedPrograms)
+
+	var report ProgramRemapReport
+	var substitutions = make(map[int]int)
+
+	for _, program := range usedPrograms {
+		var used = usage[program]
+		target, isMapped := mapping[program]
+
+		if isMapped {
+			if !hasInstrument(bank, target) {
+				return nil, nil, errors.New(fmt.Sprintf("%s is mapped to %s which isn't in the bank", programName(program), programName(target)))
+			}
+		} else if hasInstrument(bank, program) {
+			continue
+		} else {
+			target, _ = findSubstituteProgram(bank, program, used)
+
+			if target == -1 {
+				return nil, nil, errors.New(fmt.Sprintf("The bank has no instruments to play %s", programName(program)))
+			}
+		}
+
+		if target == program {
+			continue
+		}
+
+		substitutions[program] = target
+		report.Substitutions = append(report.Substitutions, ProgramSubstitution{
+			From:         program,
+			To:           target,
+			Mapped:       isMapped,
+			NoteCount:    len(used.notes),
+			MissingNotes: countMissingNotes(bank, target, used),
+		})
+	}
+
+	return ReplacePrograms(midiFile, substitutions), &report, nil
+}
+
+// channels that play notes before their first program change
+func channelsUsingDefaultProgram(midiFile *midi.Midi) [16]bool {
+	var result [16]bool
+	var hasProgramChange [16]bool
+
+	hasProgramChange[9] = true
+
+	for _, event := range midi.MergeTracks(midiFile).Tracks[0].Events {
+		if event.EventType == midi.ProgramChange {
+			hasProgramChange[event.Channel] = true
+		} else if event.EventType == midi.MidiOn && !midi.IsNoteOff(event) && !hasProgramChange[event.Channel] {
+			result[event.Channel] = true
+		}
+	}
+
+	return result
+}
+
+// ReplacePrograms changes the program of every program change event found in
+// substitutions. If program 0 is replaced then channels that play notes before
+// their first program change are given a program change at the start
+func ReplacePrograms(midiFile *midi.Midi, substitutions map[int]int) *midi.Midi {
+	var usesDefaultProgram = channelsUsingDefaultProgram(midiFile)
+
+	var result = &midi.Midi{
+		Type:            midiFile.Type,
+		TicksPerQuarter: midiFile.TicksPerQuarter,
+		Tracks:          nil,
+		UnknownChunks:   midiFile.UnknownChunks,
+	}
+
+	for trackIndex, track := range midiFile.Tracks {
+		var newTrack midi.Track
+
+		if defaultTarget, ok := substitutions[0]; ok && trackIndex == 0 {
+			for channel, usesDefault := range usesDefaultProgram {
+				if usesDefault {
+					newTrack.Events = append(newTrack.Events, &midi.MidiEvent{
+						AbsoluteTime: 0,
+						EventType:    midi.ProgramChange,
+						Channel:      uint8(channel),
+						FirstParam:   uint8(defaultTarget),
+						SecondParam:  0,
+						Metadata:     nil,
+					})
+				}
+			}
+		}
+
+		for _, event := range track.Events {
+			target, ok := substitutions[int(event.FirstParam)]
+
+			if event.EventType == midi.ProgramChange && ok {
+				var changed = *event
+				changed.FirstParam = uint8(target)
+				newTrack.Events = append(newTrack.Events, &changed)
+			} else {
+				newTrack.Events = append(newTrack.Events, event)
+			}
+		}
+
+		result.Tracks = append(result.Tracks, &newTrack)
+	}
+
+	return result
+}
+
+func (report *ProgramRemapReport) Write(out io.Writer) {
+	for _, substitution := range report.Substitutions {
+		var reason = "not in the bank"
+
+		if substitution.Mapped {
+			reason = "mapped"
+		}
+
+		fmt.Fprintf(
+			out,
+			"%s -> %s, %s, %d notes",
+			programName(substitution.From),
+			programName(substitution.To),
+			reason,
+			substitution.NoteCount,
+		)
+
+		if substitution.MissingNotes > 0 {
+			fmt.Fprintf(out, ", %d notes have no sound in the new instrument", substitution.MissingNotes)
+		}
+
+		fmt.Fprintln(out)
+	}
+}
@@ -40,30 +40,43 @@ func getUsedInstrument(bank *al64.ALBank, instrumentNumber int, key uint8, veloc
 	return nil, nil
 }
 
+// every note that can't be played is listed in the error
 func markUsedSounds(bank *al64.ALBank, seqArray []*midi.Midi, into map[interface{}]bool) error {
 	var programs [16]int
+	var missing []string = nil
+	var alreadyMissing = make(map[string]bool)
 
 	programs[9] = percussionChannel
 
 	for _, seq := range seqArray {
-		for _, track := range seq.Tracks {
-			for _, event := range track.Events {
-				if event.EventType == midi.ProgramChange {
-					programs[event.Channel] = int(event.FirstParam)
-				} else if event.EventType == midi.MidiOn {
-					inst, sound := getUsedInstrument(bank, programs[event.Channel], event.FirstParam, event.SecondParam)
-
-					if inst == nil || sound == nil {
-						return errors.New(fmt.Sprintf("Could not find instrument mapped to key=%d vel=%d instrument=%d", event.FirstParam, event.SecondParam, programs[event.Channel]))
+		// program changes in one track apply to notes in other tracks
+		for _, event := range midi.MergeTracks(seq).Tracks[0].Events {
+			if event.EventType == midi.ProgramChange {
+				programs[event.Channel] = int(event.FirstParam)
+			} else if event.EventType == midi.MidiOn && !midi.IsNoteOff(event) {
+				inst, sound := getUsedInstrument(bank, programs[event.Channel], event.FirstParam, event.SecondParam)
+
+				if inst == nil || sound == nil {
+					var message = fmt.Sprintf("Could not find instrument mapped to key=%d vel=%d instrument=%d", event.FirstParam, event.SecondParam, programs[event.Channel])
+
+					if !alreadyMissing[message] {
+						alreadyMissing[message] = true
+						missing = append(missing, message)
 					}
 
-					into[inst] = true
-					into[sound] = true
+					continue
 				}
+
+				into[inst] = true
+				into[sound] = true
 			}
 		}
 	}
 
+	if len(missing) > 0 {
+		return errors.New(strings.Join(missing, "\n"))
+	}
+
 	return nil
 }
 
@@ -116,11 +129,37 @@ func RemoveUnusedSounds(bank *al64.ALBank, seqArray []*midi.Midi) (*al64.ALBank,
 	return &result, nil
 }
 
-func ParseBankUsageFile(bankUsage string) ([][]*midi.Midi, error) {
+// CompactInstruments moves the instruments in a bank next to each other so
+// there are no empty program numbers. Returns the new bank and the new
+// program number for each instrument that moved
+func CompactInstruments(bank *al64.ALBank) (*al64.ALBank, map[int]int) {
+	var result = *bank
+	var programs = make(map[int]int)
+
+	result.InstArray = nil
+
+	for program, inst := range bank.InstArray {
+		if inst == nil {
+			continue
+		}
+
+		if program != len(result.InstArray) {
+			programs[program] = len(result.InstArray)
+		}
+
+		result.InstArray = append(result.InstArray, inst)
+	}
+
+	return &result, programs
+}
+
+// ParseBankUsageFile returns the midi files used by each bank
+// along with the path to each midi file
+func ParseBankUsageFile(bankUsage string) ([][]*midi.Midi, [][]string, error) {
 	textData, err := ioutil.ReadFile(bankUsage)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	lines := strings.Split(string(textData), "\n")
@@ -128,6 +167,7 @@ func ParseBankUsageFile(bankUsage string) ([][]*midi.Midi, error) {
 	var currBank = 0
 
 	var result [][]*midi.Midi = nil
+	var filenames [][]string = nil
 
 	for _, line := range lines {
 		var trimmed = strings.TrimSpace(line)
@@ -143,10 +183,11 @@ func ParseBankUsageFile(bankUsage string) ([][]*midi.Midi, error) {
 			continue
 		}
 
-		midFile, err := os.Open(filepath.Join(filepath.Dir(bankUsage), trimmed))
+		var filename = filepath.Join(filepath.Dir(bankUsage), trimmed)
+		midFile, err := os.Open(filename)
 
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		defer midFile.Close()
@@ -154,15 +195,17 @@ func ParseBankUsageFile(bankUsage string) ([][]*midi.Midi, error) {
 		midi, err := midi.ReadMidi(midFile)
 
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		for currBank >= len(result) {
 			result = append(result, nil)
+			filenames = append(filenames, nil)
 		}
 
 		result[currBank] = append(result[currBank], midi)
+		filenames[currBank] = append(filenames[currBank], filename)
 	}
 
-	return result, nil
+	return result, filenames, nil
 }
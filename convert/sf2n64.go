@@ -0,0 +1,385 @@
+package convert
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/lambertjamesd/sfz2n64/al64"
+	"github.com/lambertjamesd/sfz2n64/audioconvert"
+	"github.com/lambertjamesd/sfz2n64/sf2"
+)
+
+// generators that use timecents and default to a near instant time
+const sf2MinTimecents = -12000
+
+type sf2Wavetable struct {
+	sample    uint16
+	start     int
+	end       int
+	loopStart int
+	loopEnd   int
+	loop      bool
+}
+
+type sf2ConversionState struct {
+	input      *sf2.Sf2File
+	wavetables map[sf2Wavetable]*al64.ALWavetable
+}
+
+// sf2ZoneValues holds the zones with generators that apply to a
+// single instrument zone played through a single preset zone
+type sf2ZoneValues struct {
+	instrumentZone   *sf2.Zone
+	instrumentGlobal *sf2.Zone
+	presetZone       *sf2.Zone
+	presetGlobal     *sf2.Zone
+}
+
+func sf2FindGenerator(zone *sf2.Zone, global *sf2.Zone, operator sf2.GeneratorOperator) *sf2.Generator {
+	var result = zone.FindGenerator(operator)
+
+	if result == nil {
+		result = global.FindGenerator(operator)
+	}
+
+	return result
+}
+
+// instrument generators are absolute while preset
+// generators are added onto the instrument value
+func (values *sf2ZoneValues) getInt(operator sf2.GeneratorOperator, defaultValue int) int {
+	var result = defaultValue
+
+	var instrumentGen = sf2FindGenerator(values.instrumentZone, values.instrumentGlobal, operator)
+
+	if instrumentGen != nil {
+		result = instrumentGen.AsInt()
+	}
+
+	var presetGen = sf2FindGenerator(values.presetZone, values.presetGlobal, operator)
+
+	if presetGen != nil {
+		result = result + presetGen.AsInt()
+	}
+
+	return result
+}
+
+// ranges of the instrument and preset are intersected
+func (values *sf2ZoneValues) getRange(operator sf2.GeneratorOperator) (uint8, uint8) {
+	var low uint8 = 0
+	var high uint8 = 127
+
+	for _, generator := range []*sf2.Generator{
+		sf2FindGenerator(values.instrumentZone, values.instrumentGlobal, operator),
+		sf2FindGenerator(values.presetZone, values.presetGlobal, operator),
+	} {
+		if generator != nil {
+			genLow, genHigh := generator.AsRange()
+
+			if genLow > low {
+				low = genLow
+			}
+
+			if genHigh < high {
+				high = genHigh
+			}
+		}
+	}
+
+	return low, high
+}
+
+func (values *sf2ZoneValues) getOffset(fine sf2.GeneratorOperator, coarse sf2.GeneratorOperator) int {
+	return values.getInt(fine, 0) + values.getInt(coarse, 0)*32768
+}
+
+func sf2TimecentsToMicroseconds(timecents int) int32 {
+	if timecents <= sf2MinTimecents {
+		return 0
+	}
+
+	return int32(1000000 * math.Pow(2, float64(timecents)/1200))
+}
+
+func sf2CentibelsToVolume(centibels int) uint8 {
+	if centibels <= 0 {
+		return 127
+	}
+
+	return uint8(math.Round(127 * math.Pow(10, -float64(centibels)/200)))
+}
+
+func clampInt(value int, min int, max int) int {
+	if value < min {
+		return min
+	} else if value > max {
+		return max
+	}
+
+	return value
+}
+
+func sf2ParseKeyMap(values *sf2ZoneValues, sample *sf2.Sample) *al64.ALKeyMap {
+	var keyMap al64.ALKeyMap
+
+	keyMap.KeyMin, keyMap.KeyMax = values.getRange(sf2.GenKeyRange)
+	keyMap.VelocityMin, keyMap.VelocityMax = values.getRange(sf2.GenVelRange)
+
+	var rootKey = values.getInt(sf2.GenOverridingRootKey, -1)
+
+	if rootKey < 0 || rootKey > 127 {
+		rootKey = int(sample.OriginalPitch)
+	}
+
+	if rootKey > 127 {
+		rootKey = 60
+	}
+
+	keyMap.KeyBase = uint8(rootKey)
+
+	var detune = values.getInt(sf2.GenCoarseTune, 0)*100 +
+		values.getInt(sf2.GenFineTune, 0) +
+		int(sample.PitchCorrection)
+
+	setKeyMapDetune(&keyMap, int64(detune))
+
+	return &keyMap
+}
+
+func sf2ParseEnvelope(values *sf2ZoneValues) *al64.ALEnvelope {
+	var result al64.ALEnvelope
+
+	result.AttackVolume = 127
+	result.AttackTime = sf2TimecentsToMicroseconds(values.getInt(sf2.GenAttackVolEnv, sf2MinTimecents))
+	result.ReleaseTime = sf2TimecentsToMicroseconds(values.getInt(sf2.GenReleaseVolEnv, sf2MinTimecents))
+
+	var sustain = clampInt(values.getInt(sf2.GenSustainVolEnv, 0), 0, 1000)
+
+	result.DecayVolume = sf2CentibelsToVolume(sustain)
+
+	// the decay time in a soundfont is the time it would take
+	// to reach silence so it is scaled to reach the sustain level
+	var decayTime = sf2TimecentsToMicroseconds(values.getInt(sf2.GenDecayVolEnv, sf2MinTimecents))
+	result.DecayTime = int32(int64(decayTime) * int64(sustain) / 1000)
+
+	return &result
+}
+
+func (state *sf2ConversionState) getWavetable(values *sf2ZoneValues, sampleIndex uint16) (*al64.ALWavetable, error) {
+	var sample = state.input.Samples[sampleIndex]
+
+	if sample.SampleType&0x8000 != 0 {
+		return nil, errors.New(fmt.Sprintf("Sample %s is stored in rom and cannot be converted", sample.Name))
+	}
+
+	var sampleMode = values.getInt(sf2.GenSampleModes, sf2.LoopNone)
+
+	var key = sf2Wavetable{
+		sample:    sampleIndex,
+		start:     int(sample.Start) + values.getOffset(sf2.GenStartAddrsOffset, sf2.GenStartAddrsCoarseOffset),
+		end:       int(sample.End) + values.getOffset(sf2.GenEndAddrsOffset, sf2.GenEndAddrsCoarseOffset),
+		loopStart: int(sample.StartLoop) + values.getOffset(sf2.GenStartloopAddrsOffset, sf2.GenStartloopAddrsCoarseOffset),
+		loopEnd:   int(sample.EndLoop) + values.getOffset(sf2.GenEndloopAddrsOffset, sf2.GenEndloopAddrsCoarseOffset),
+		loop:      sampleMode == sf2.LoopContinuous || sampleMode == sf2.LoopUntilRelease,
+	}
+
+	key.start = clampInt(key.start, 0, len(state.input.SampleData))
+	key.end = clampInt(key.end, key.start, len(state.input.SampleData))
+
+	if key.loop {
+		key.loopStart = clampInt(key.loopStart, key.start, key.end)
+		key.loopEnd = clampInt(key.loopEnd, key.loopStart, key.end)
+		key.loop = key.loopEnd > key.loopStart
+	}
+
+	if !key.loop {
+		key.loopStart = 0
+		key.loopEnd = 0
+	}
+
+	existing, ok := state.wavetables[key]
+
+	if ok {
+		return existing, nil
+	}
+
+	var data = audioconvert.EncodeSamples(state.input.SampleData[key.start:key.end], binary.BigEndian)
+
+	var result = &al64.ALWavetable{
+		Base:           0,
+		Len:            int32(len(data)),
+		Type:           al64.AL_RAW16_WAVE,
+		AdpcWave:       al64.ALADPCMWaveInfo{Loop: nil, Book: nil},
+		RawWave:        al64.ALRAWWaveInfo{Loop: nil},
+		DataFromTable:  data,
+		FileSampleRate: sample.SampleRate,
+	}
+
+	if key.loop {
+		result.RawWave.Loop = &al64.ALRawLoop{
+			Start: uint32(key.loopStart - key.start),
+			End:   uint32(key.loopEnd - key.start),
+			Count: ^uint32(0),
+		}
+	}
+
+	state.wavetables[key] = result
+
+	return result, nil
+}
+
+func (state *sf2ConversionState) parseSound(values *sf2ZoneValues) (*al64.ALSound, error) {
+	var sampleIndex = values.instrumentZone.FindGenerator(sf2.GenSampleID).Amount
+	var sample = state.input.Samples[sampleIndex]
+
+	wavetable, err := state.getWavetable(values, sampleIndex)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pan = clampInt(values.getInt(sf2.GenPan, 0), -500, 500)
+
+	return &al64.ALSound{
+		Envelope:     sf2ParseEnvelope(values),
+		KeyMap:       sf2ParseKeyMap(values, sample),
+		Wavetable:    wavetable,
+		SamplePan:    uint8(((pan+500)*127 + 500) / 1000),
+		SampleVolume: sf2CentibelsToVolume(values.getInt(sf2.GenInitialAttenuation, 0)),
+	}, nil
+}
+
+func (state *sf2ConversionState) parsePreset(preset *sf2.Preset) (*al64.ALInstrument, error) {
+	var result al64.ALInstrument
+
+	result.Volume = 127
+	result.Pan = 64
+	// the default pitch wheel sensitivity of a soundfont is 2 semitones
+	result.BendRange = 200
+
+	for _, presetZone := range preset.Zones {
+		var instrument = state.input.Instruments[presetZone.FindGenerator(sf2.GenInstrument).Amount]
+
+		for _, instrumentZone := range instrument.Zones {
+			var values = sf2ZoneValues{
+				instrumentZone:   instrumentZone,
+				instrumentGlobal: instrument.GlobalZone,
+				presetZone:       presetZone,
+				presetGlobal:     preset.GlobalZone,
+			}
+
+			keyMin, keyMax := values.getRange(sf2.GenKeyRange)
+			velMin, velMax := values.getRange(sf2.GenVelRange)
+
+			if keyMin > keyMax || velMin > velMax {
+				continue
+			}
+
+			var sample = state.input.Samples[instrumentZone.FindGenerator(sf2.GenSampleID).Amount]
+
+			// the n64 doesn't support stereo sounds so only
+			// the left side of a stereo sample is used
+			if sample.SampleType&^0x8000 == sf2.RightSample {
+				continue
+			}
+
+			sound, err := state.parseSound(&values)
+
+			if err != nil {
+				return nil, err
+			}
+
+			result.SoundArray = append(result.SoundArray, sound)
+		}
+	}
+
+	return &result, nil
+}
+
+// Sf2N64 creates an ALBank for each melodic bank in the soundfont with the
+// preset numbers used as the program number. The first preset in the
+// percussion bank 128 is used as the percussion instrument of every bank
+func Sf2N64(input *sf2.Sf2File) (*al64.ALBankFile, error) {
+	var state = sf2ConversionState{
+		input:      input,
+		wavetables: make(map[sf2Wavetable]*al64.ALWavetable),
+	}
+
+	var result al64.ALBankFile
+	var banks = make(map[uint16]*al64.ALBank)
+	var bankNumbers []int = nil
+	var percussion *al64.ALInstrument = nil
+	var percussionPreset = -1
+
+	for _, preset := range input.Presets {
+		if preset.Bank == sf2.PERCUSSION_BANK {
+			if percussionPreset != -1 && int(preset.Preset) > percussionPreset {
+				continue
+			}
+
+			instrument, err := state.parsePreset(preset)
+
+			if err != nil {
+				return nil, err
+			}
+
+			percussion = instrument
+			percussionPreset = int(preset.Preset)
+			continue
+		}
+
+		if preset.Preset > 127 || preset.Bank > 127 {
+			return nil, errors.New(fmt.Sprintf("Preset %s has an invalid preset number %d:%d", preset.Name, preset.Bank, preset.Preset))
+		}
+
+		bank, ok := banks[preset.Bank]
+
+		if !ok {
+			bank = &al64.ALBank{SampleRate: 0, Percussion: nil, InstArray: nil}
+			banks[preset.Bank] = bank
+			bankNumbers = append(bankNumbers, int(preset.Bank))
+		}
+
+		instrument, err := state.parsePreset(preset)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for int(preset.Preset) >= len(bank.InstArray) {
+			bank.InstArray = append(bank.InstArray, nil)
+		}
+
+		bank.InstArray[preset.Preset] = instrument
+	}
+
+	sort.Ints(bankNumbers)
+
+	for _, bankNumber := range bankNumbers {
+		result.BankArray = append(result.BankArray, banks[uint16(bankNumber)])
+	}
+
+	if percussion != nil {
+		if len(result.BankArray) == 0 {
+			result.BankArray = append(result.BankArray, &al64.ALBank{SampleRate: 0, Percussion: nil, InstArray: nil})
+		}
+
+		for _, bank := range result.BankArray {
+			bank.Percussion = percussion
+		}
+	}
+
+	if len(result.BankArray) == 0 {
+		return nil, errors.New("sf2 file does not have any presets")
+	}
+
+	for _, bank := range result.BankArray {
+		bank.SampleRate = audioconvert.ChooseBankSampleRate(bank)
+	}
+
+	return &result, nil
+}
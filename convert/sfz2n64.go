@@ -50,6 +50,22 @@ func sfzParseRange(absValueStr string, lowValueStr string, highValueStr string)
 	return lowResult, highResult, nil
 }
 
+// moves whole semitones of detune into KeyBase so
+// detune stays in the range of -50 to 50 cents
+func setKeyMapDetune(keyMap *al64.ALKeyMap, detune int64) {
+	for detune > 50 {
+		detune -= 100
+		keyMap.KeyBase--
+	}
+
+	for detune < -50 {
+		detune += 100
+		keyMap.KeyBase++
+	}
+
+	keyMap.Detune = uint8(detune)
+}
+
 func sfzParseKeyMap(region *sfz.SfzFullRegion) (*al64.ALKeyMap, error) {
 	var keyMap al64.ALKeyMap
 
@@ -110,20 +126,30 @@ func sfzParseKeyMap(region *sfz.SfzFullRegion) (*al64.ALKeyMap, error) {
 			return nil, err
 		}
 
-		for detune > 50 {
-			detune -= 100
-			keyMap.KeyBase--
-		}
+		setKeyMapDetune(&keyMap, detune)
+	}
 
-		for detune < -50 {
-			detune += 100
-			keyMap.KeyBase++
-		}
+	return &keyMap, nil
+}
+
+// createEnvelope takes times in seconds and a sustain level from 0 to 100
+func createEnvelope(attackTime float64, decayTime float64, releaseTime float64, sustainLevel float64) *al64.ALEnvelope {
+	var result al64.ALEnvelope
+
+	result.AttackVolume = 127
+	result.AttackTime = int32(attackTime * 1000000)
+	result.DecayTime = int32(decayTime * 1000000)
+	result.ReleaseTime = int32(releaseTime * 1000000)
 
-		keyMap.Detune = uint8(detune)
+	if sustainLevel >= 100 {
+		result.DecayVolume = 127
+	} else if sustainLevel < 0 {
+		result.DecayVolume = 0
+	} else {
+		result.DecayVolume = uint8(sustainLevel / 100 * 127)
 	}
 
-	return &keyMap, nil
+	return &result
 }
 
 func sfzParseEnvelope(region *sfz.SfzFullRegion) (*al64.ALEnvelope, error) {
@@ -136,10 +162,6 @@ func sfzParseEnvelope(region *sfz.SfzFullRegion) (*al64.ALEnvelope, error) {
 		return nil, nil
 	}
 
-	var result al64.ALEnvelope
-
-	result.AttackVolume = 127
-
 	var attackTime float64
 	var decayTime float64
 	var releaseTime float64
@@ -153,8 +175,6 @@ func sfzParseEnvelope(region *sfz.SfzFullRegion) (*al64.ALEnvelope, error) {
 		}
 	}
 
-	result.AttackTime = int32(attackTime * 1000000)
-
 	if decay != "" {
 		decayTime, err = strconv.ParseFloat(decay, 64)
 
@@ -163,8 +183,6 @@ func sfzParseEnvelope(region *sfz.SfzFullRegion) (*al64.ALEnvelope, error) {
 		}
 	}
 
-	result.DecayTime = int32(decayTime * 1000000)
-
 	if release != "" {
 		releaseTime, err = strconv.ParseFloat(release, 64)
 
@@ -173,8 +191,6 @@ func sfzParseEnvelope(region *sfz.SfzFullRegion) (*al64.ALEnvelope, error) {
 		}
 	}
 
-	result.ReleaseTime = int32(releaseTime * 1000000)
-
 	var decayVolume float64
 
 	if sustainLevel != "" {
@@ -187,15 +203,7 @@ func sfzParseEnvelope(region *sfz.SfzFullRegion) (*al64.ALEnvelope, error) {
 		decayVolume = 100
 	}
 
-	if decayVolume >= 100 {
-		result.DecayVolume = 127
-	} else if decayVolume < 0 {
-		result.DecayVolume = 0
-	} else {
-		result.DecayVolume = uint8(decayVolume / 100 * 127)
-	}
-
-	return &result, nil
+	return createEnvelope(attackTime, decayTime, releaseTime, decayVolume), nil
 }
 
 func sfzParseLoop(region *sfz.SfzFullRegion, sound *al64.ALSound) error {
@@ -241,25 +249,55 @@ func sfzParseLoop(region *sfz.SfzFullRegion, sound *al64.ALSound) error {
 	return nil
 }
 
-func sfzParseSound(region *sfz.SfzFullRegion) (*al64.ALSound, error) {
+// pan ranges from -100 to 100
+func panFromPercent(pan float64) uint8 {
+	if pan > 100 {
+		return 127
+	} else if pan < -100 {
+		return 0
+	} else {
+		return uint8((pan + 100) * 127 / 200)
+	}
+}
+
+func volumeFromDecibels(volume float64) uint8 {
+	if volume >= 0 {
+		return 127
+	} else {
+		var linearScale = math.Pow(1.071773463, volume)
+		return uint8(linearScale * 127)
+	}
+}
+
+func sfzParseSound(region *sfz.SfzFullRegion, pcmSettings *audioconvert.PCMConversionSettings) (*al64.ALSound, error) {
 	filename := region.FindValue("sample")
 
 	if filename == "" {
 		return nil, errors.New("Region missing sample")
 	}
 
-	result, err := audioconvert.ReadWavetable(filename)
+	cached, err := audioconvert.ReadWavetable(filename, pcmSettings)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// the cached sound is shared by every region using the same sample
+	var soundCopy = *cached
+	var result = &soundCopy
+
 	keyMap, err := sfzParseKeyMap(region)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// use the tuning stored in the sample if the region doesn't have any
+	if cached.KeyMap != nil && region.FindValue("pitch_keycenter") == "" && region.FindValue("tune") == "" {
+		keyMap.KeyBase = cached.KeyMap.KeyBase
+		keyMap.Detune = cached.KeyMap.Detune
+	}
+
 	result.KeyMap = keyMap
 
 	env, err := sfzParseEnvelope(region)
@@ -291,13 +329,7 @@ func sfzParseSound(region *sfz.SfzFullRegion) (*al64.ALSound, error) {
 			return nil, err
 		}
 
-		if panAsFloat > 100 {
-			result.SamplePan = 127
-		} else if panAsFloat < -100 {
-			result.SamplePan = 0
-		} else {
-			result.SamplePan = uint8((panAsFloat + 100) * 127 / 200)
-		}
+		result.SamplePan = panFromPercent(panAsFloat)
 	}
 
 	volume := region.FindValue("volume")
@@ -311,12 +343,7 @@ func sfzParseSound(region *sfz.SfzFullRegion) (*al64.ALSound, error) {
 			return nil, err
 		}
 
-		if volumeAsFloat >= 0 {
-			result.SampleVolume = 127
-		} else {
-			var linearScale = math.Pow(1.071773463, volumeAsFloat)
-			result.SampleVolume = uint8(linearScale * 127)
-		}
+		result.SampleVolume = volumeFromDecibels(volumeAsFloat)
 	}
 
 	sfzParseLoop(region, result)
@@ -363,7 +390,7 @@ func sfzParseSound(region *sfz.SfzFullRegion) (*al64.ALSound, error) {
 	return result, nil
 }
 
-func sfzParseInstrument(sfzFile *sfz.SfzFile) (*al64.ALInstrument, error) {
+func sfzParseInstrument(sfzFile *sfz.SfzFile, pcmSettings *audioconvert.PCMConversionSettings) (*al64.ALInstrument, error) {
 	var fullRegion sfz.SfzFullRegion
 
 	var instrument al64.ALInstrument
@@ -375,7 +402,7 @@ func sfzParseInstrument(sfzFile *sfz.SfzFile) (*al64.ALInstrument, error) {
 			fullRegion.Group = section
 		} else if section.Name == "<region>" {
 			fullRegion.Region = section
-			sound, err := sfzParseSound(&fullRegion)
+			sound, err := sfzParseSound(&fullRegion, pcmSettings)
 
 			if err != nil {
 				return nil, err
@@ -391,14 +418,14 @@ func sfzParseInstrument(sfzFile *sfz.SfzFile) (*al64.ALInstrument, error) {
 	return &instrument, nil
 }
 
-func sfzParseInstrumentFile(filename string) (*al64.ALInstrument, error) {
+func sfzParseInstrumentFile(filename string, pcmSettings *audioconvert.PCMConversionSettings) (*al64.ALInstrument, error) {
 	sfzFile, err := sfz.ParseSfz(filename)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return sfzParseInstrument(sfzFile)
+	return sfzParseInstrument(sfzFile, pcmSettings)
 }
 
 func SfzIsSingleInstrument(input *sfz.SfzFile) bool {
@@ -410,7 +437,7 @@ func SfzIsSingleInstrument(input *sfz.SfzFile) bool {
 	return true
 }
 
-func sfzParseAsBankFile(input *sfz.SfzFile, sfzFilename string) (*al64.ALBankFile, error) {
+func sfzParseAsBankFile(input *sfz.SfzFile, sfzFilename string, pcmSettings *audioconvert.PCMConversionSettings) (*al64.ALBankFile, error) {
 	var result al64.ALBankFile
 	var currentBank *al64.ALBank
 
@@ -440,17 +467,13 @@ func sfzParseAsBankFile(input *sfz.SfzFile, sfzFilename string) (*al64.ALBankFil
 			var instrumentName = section.FindValue("instrument")
 
 			if instrumentName != "" {
-				inst, err := sfzParseInstrumentFile(filepath.Join(filepath.Dir(sfzFilename), instrumentName))
+				inst, err := sfzParseInstrumentFile(filepath.Join(filepath.Dir(sfzFilename), instrumentName), pcmSettings)
 
 				if err != nil {
 					return nil, err
 				}
 
 				currentBank.Percussion = inst
-
-				if len(inst.SoundArray) > 0 {
-					currentBank.SampleRate = inst.SoundArray[0].Wavetable.FileSampleRate
-				}
 			} else {
 				return nil, errors.New("<percussion> section defined without an instrument")
 			}
@@ -491,31 +514,31 @@ func sfzParseAsBankFile(input *sfz.SfzFile, sfzFilename string) (*al64.ALBankFil
 				return nil, errors.New("<instrument> section defined without an instrument")
 			}
 
-			inst, err := sfzParseInstrumentFile(filepath.Join(filepath.Dir(sfzFilename), instrumentName))
+			inst, err := sfzParseInstrumentFile(filepath.Join(filepath.Dir(sfzFilename), instrumentName), pcmSettings)
 
 			if err != nil {
 				return nil, err
 			}
 
 			currentBank.InstArray[programNumber] = inst
-
-			if len(inst.SoundArray) > 0 {
-				currentBank.SampleRate = inst.SoundArray[0].Wavetable.FileSampleRate
-			}
 		}
 	}
 
+	for _, bank := range result.BankArray {
+		bank.SampleRate = audioconvert.ChooseBankSampleRate(bank)
+	}
+
 	result.CorrectOverlap()
 
 	return &result, nil
 }
 
-func sfzParseAsSingleInstrument(input *sfz.SfzFile) (*al64.ALBankFile, error) {
+func sfzParseAsSingleInstrument(input *sfz.SfzFile, pcmSettings *audioconvert.PCMConversionSettings) (*al64.ALBankFile, error) {
 	var result al64.ALBankFile
 	var currentBank *al64.ALBank
 	currentBank = &al64.ALBank{SampleRate: 0, Percussion: nil, InstArray: nil}
 	result.BankArray = append(result.BankArray, currentBank)
-	inst, err := sfzParseInstrument(input)
+	inst, err := sfzParseInstrument(input, pcmSettings)
 
 	if err != nil {
 		return nil, err
@@ -524,17 +547,15 @@ func sfzParseAsSingleInstrument(input *sfz.SfzFile) (*al64.ALBankFile, error) {
 	currentBank.InstArray = make([]*al64.ALInstrument, 1)
 	currentBank.InstArray[0] = inst
 
-	if len(inst.SoundArray) > 0 {
-		currentBank.SampleRate = inst.SoundArray[0].Wavetable.FileSampleRate
-	}
+	currentBank.SampleRate = audioconvert.ChooseBankSampleRate(currentBank)
 
 	return &result, nil
 }
 
-func Sfz2N64(input *sfz.SfzFile, sfzFilename string) (*al64.ALBankFile, error) {
+func Sfz2N64(input *sfz.SfzFile, sfzFilename string, pcmSettings *audioconvert.PCMConversionSettings) (*al64.ALBankFile, error) {
 	if SfzIsSingleInstrument(input) {
-		return sfzParseAsSingleInstrument(input)
+		return sfzParseAsSingleInstrument(input, pcmSettings)
 	} else {
-		return sfzParseAsBankFile(input, sfzFilename)
+		return sfzParseAsBankFile(input, sfzFilename, pcmSettings)
 	}
 }
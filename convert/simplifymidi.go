@@ -1,20 +1,23 @@
 package convert
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"sort"
 
 	"github.com/lambertjamesd/sfz2n64/al64"
 	"github.com/lambertjamesd/sfz2n64/midi"
 )
 
+// notes are matched by channel and key so a program change
+// while a note is held doesn't stop the note off from matching
 type noteKey struct {
-	instrument uint8
-	node       uint8
+	channel uint8
+	node    uint8
 }
 
-func noteKeyFromMidi(programs *[16]int, event *midi.MidiEvent) noteKey {
-	return noteKey{uint8(programs[event.Channel]), uint8(event.FirstParam)}
+func noteKeyFromMidi(event *midi.MidiEvent) noteKey {
+	return noteKey{event.Channel, uint8(event.FirstParam)}
 }
 
 type activeNote struct {
@@ -22,134 +25,449 @@ type activeNote struct {
 	currentSound      *al64.ALSound
 	key               noteKey
 	channel           uint8
+	priority          uint8
+	velocity          uint8
+	program           int
+	startTick         uint32
+	startMicroseconds int
+	// true once the note off has been received
+	released bool
+	// true if the note off was received while the sustain pedal was down
+	sustained bool
 }
 
+// notes can't be ended early with a note off while the sustain pedal is down
+func canStealNote(note *activeNote, sustain *[16]bool) bool {
+	return note.released || !sustain[note.channel]
+}
+
+// notes that are released are stolen first, then notes with the lowest
+// instrument priority, lowest velocity, and finally the oldest note
+func isLessImportant(a *activeNote, b *activeNote) bool {
+	if a.released != b.released {
+		return a.released
+	} else if a.priority != b.priority {
+		return a.priority < b.priority
+	} else if a.velocity != b.velocity {
+		return a.velocity < b.velocity
+	}
+
+	return a.startMicroseconds < b.startMicroseconds
+}
+
+type VoiceChangeType int
+
+const (
+	// a playing note was ended early to make room for another note
+	VOICE_NOTE_TRUNCATED VoiceChangeType = iota
+	// a note was never started because there were no voices left
+	VOICE_NOTE_REMOVED
+)
+
+func (changeType VoiceChangeType) MarshalText() ([]byte, error) {
+	if changeType == VOICE_NOTE_TRUNCATED {
+		return []byte("truncated"), nil
+	}
+
+	return []byte("removed"), nil
+}
+
+type VoiceChange struct {
+	Type    VoiceChangeType `json:"type"`
+	Tick    uint32          `json:"tick"`
+	Channel uint8           `json:"channel"`
+	Key     uint8           `json:"key"`
+	// the note that was played in place of the changed note
+	ForChannel uint8 `json:"forChannel"`
+	ForKey     uint8 `json:"forKey"`
+}
+
+func (change *VoiceChange) String() string {
+	if change.Type == VOICE_NOTE_TRUNCATED {
+		return fmt.Sprintf(
+			"tick %d: ended channel %d note %d early to play channel %d note %d",
+			change.Tick,
+			change.Channel,
+			change.Key,
+			change.ForChannel,
+			change.ForKey,
+		)
+	}
+
+	return fmt.Sprintf("tick %d: removed channel %d note %d, no voices left", change.Tick, change.Channel, change.Key)
+}
+
+type SimplifyMidiResult struct {
+	Midi *midi.Midi
+	// the polyphony of the song after any changes
+	Report *PolyphonyReport
+}
+
+type tempoChange struct {
+	tick             uint32
+	microseconds     float64
+	microsPerQuarter int
+}
+
+// converts ticks to microseconds using the tempo
+// changes from every track of the midi file
 type midiTime struct {
-	ticksPerQuarter  int
-	microsPerQuater  int
-	currentMicroSecs int
-	lastTick         int
+	ticksPerQuarter int
+	// only used for smpte time division
+	microsPerTick float64
+	tempoMap      []tempoChange
 }
 
 const noNoteEnd int = -1
+const sustainController = 64
+const defaultMicrosPerQuarter = 500000
+
+func smpteMicrosPerTick(midiFile *midi.Midi) float64 {
+	framesPerSecondInt, ticksPerFrameInt := midiFile.SMPTEFormat()
+	var framesPerSecond = float64(framesPerSecondInt)
+	var ticksPerFrame = float64(ticksPerFrameInt)
+
+	// 29 is used for 30 drop frame
+	if framesPerSecond == 29 {
+		framesPerSecond = 29.97
+	}
 
-func newMidiTime(ticksPerQuarter int) midiTime {
-	return midiTime{
-		ticksPerQuarter,
-		500000,
-		0,
-		0,
+	if framesPerSecond <= 0 || ticksPerFrame == 0 {
+		return 0
 	}
+
+	return 1000000 / (framesPerSecond * ticksPerFrame)
 }
 
-func (time *midiTime) updateTo(tick int) {
-	var ticksPassed = tick - time.lastTick
-	time.lastTick = tick
-	time.currentMicroSecs = time.currentMicroSecs + ticksPassed*time.microsPerQuater/time.ticksPerQuarter
+func tempoFromEvent(event *midi.MidiEvent) (int, bool) {
+	if event.EventType != midi.Metadata || event.FirstParam != midi.MetaTempo || len(event.Metadata) < 3 {
+		return 0, false
+	}
+
+	var result = int(event.Metadata[0])<<16 | int(event.Metadata[1])<<8 | int(event.Metadata[2])
+
+	if result == 0 {
+		return 0, false
+	}
+
+	return result, true
 }
 
-func removeStoppedSounds(noteMapping map[noteKey]*activeNote, microSeconds int) {
-	var keysToRemove []noteKey = nil
+func newMidiTime(midiFile *midi.Midi) (midiTime, error) {
+	var result = midiTime{
+		ticksPerQuarter: int(midiFile.TicksPerQuarter),
+		microsPerTick:   0,
+		tempoMap:        []tempoChange{tempoChange{0, 0, defaultMicrosPerQuarter}},
+	}
+
+	if midiFile.IsSMPTE() {
+		result.microsPerTick = smpteMicrosPerTick(midiFile)
+
+		if result.microsPerTick == 0 {
+			return result, errors.New(fmt.Sprintf("Invalid SMPTE time division %04X", midiFile.TicksPerQuarter))
+		}
+
+		// tempo events don't change the timing of smpte files
+		return result, nil
+	} else if midiFile.TicksPerQuarter == 0 {
+		return result, errors.New("Midi file has a time division of 0")
+	}
+
+	var changes []tempoChange = nil
+
+	for _, track := range midiFile.Tracks {
+		for _, event := range track.Events {
+			tempo, isTempo := tempoFromEvent(event)
+
+			if isTempo {
+				changes = append(changes, tempoChange{event.AbsoluteTime, 0, tempo})
+			}
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].tick < changes[j].tick
+	})
 
-	for note, sound := range noteMapping {
-		if sound.untilMicroseconds < microSeconds {
-			keysToRemove = append(keysToRemove, note)
+	for _, change := range changes {
+		var last = &result.tempoMap[len(result.tempoMap)-1]
+		change.microseconds = result.microsecondsFrom(last, change.tick)
+
+		// a later tempo at the same tick replaces the earlier one
+		if change.tick == last.tick {
+			last.microsPerQuarter = change.microsPerQuarter
+		} else {
+			result.tempoMap = append(result.tempoMap, change)
 		}
 	}
 
-	for _, note := range keysToRemove {
-		delete(noteMapping, note)
+	return result, nil
+}
+
+func (time *midiTime) microsecondsFrom(change *tempoChange, tick uint32) float64 {
+	var ticksPassed = float64(tick) - float64(change.tick)
+	return change.microseconds + ticksPassed*float64(change.microsPerQuarter)/float64(time.ticksPerQuarter)
+}
+
+// the time in microseconds from the start of the song
+func (time *midiTime) microseconds(tick uint32) int {
+	if time.microsPerTick != 0 {
+		return int(float64(tick) * time.microsPerTick)
+	}
+
+	var index = sort.Search(len(time.tempoMap), func(i int) bool {
+		return time.tempoMap[i].tick > tick
+	}) - 1
+
+	if index < 0 {
+		index = 0
 	}
+
+	return int(time.microsecondsFrom(&time.tempoMap[index], tick))
 }
 
-func SimplifyMidi(midiFile *midi.Midi, bank *al64.ALBank, maxActiveSounds int) (*midi.Midi, int) {
+// the tick at the given time in microseconds from the start of the song
+func (time *midiTime) tick(microseconds int) uint32 {
+	if time.microsPerTick != 0 {
+		return uint32(float64(microseconds) / time.microsPerTick)
+	}
+
+	var index = sort.Search(len(time.tempoMap), func(i int) bool {
+		return time.tempoMap[i].microseconds > float64(microseconds)
+	}) - 1
+
+	if index < 0 {
+		index = 0
+	}
+
+	var change = &time.tempoMap[index]
+	var ticksPassed = (float64(microseconds) - change.microseconds) * float64(time.ticksPerQuarter) / float64(change.microsPerQuarter)
+
+	return change.tick + uint32(ticksPassed)
+}
+
+func removeStoppedSounds(noteMapping map[noteKey]*activeNote, microSeconds int, time *midiTime, report *PolyphonyReport) {
+	var stopped []*activeNote = nil
+
+	for _, sound := range noteMapping {
+		if sound.untilMicroseconds != noNoteEnd && sound.untilMicroseconds < microSeconds {
+			stopped = append(stopped, sound)
+		}
+	}
+
+	// notes are removed in the order they stop so the timeline stays in order
+	sort.Slice(stopped, func(i, j int) bool {
+		return stopped[i].untilMicroseconds < stopped[j].untilMicroseconds
+	})
+
+	for _, sound := range stopped {
+		delete(noteMapping, sound.key)
+		report.record(time.tick(sound.untilMicroseconds), sound.untilMicroseconds, noteMapping)
+	}
+}
+
+func releaseNote(note *activeNote, microseconds int) {
+	var releaseTime = 0
+
+	if note.currentSound.Envelope != nil {
+		releaseTime = int(note.currentSound.Envelope.ReleaseTime)
+	}
+
+	var releaseEnd = microseconds + releaseTime
+	note.released = true
+
+	// notes that already decayed to silence end at the earlier time
+	if note.untilMicroseconds == noNoteEnd || releaseEnd < note.untilMicroseconds {
+		note.untilMicroseconds = releaseEnd
+	}
+}
+
+// SimplifyMidi combines all tracks into a single track and reports the
+// polyphony of the song, including notes held by the sustain pedal.
+// Notes that never stop on their own are ended at the end of the song.
+// If maxActiveSounds isn't 0 the voice allocation is simulated and notes
+// are truncated or removed so no more than maxActiveSounds play at once
+func SimplifyMidi(midiFile *midi.Midi, bank *al64.ALBank, maxActiveSounds int) (*SimplifyMidiResult, error) {
 	var noteEndMapping = make(map[noteKey]*activeNote)
 	var programs [16]int
-
-	var maxActive = 0
+	var sustain [16]bool
+	// the number of note off events to skip for notes that were changed
+	var skipNoteOff = make(map[noteKey]int)
 
 	programs[9] = percussionChannel
 
-	var result midi.Midi = midi.Midi{
-		Type:            midi.SingleTrack,
-		TicksPerQuarter: midiFile.TicksPerQuarter,
-		Tracks:          nil,
+	var result = &SimplifyMidiResult{
+		Midi: &midi.Midi{
+			Type:            midi.SingleTrack,
+			TicksPerQuarter: midiFile.TicksPerQuarter,
+			Tracks:          nil,
+			UnknownChunks:   midiFile.UnknownChunks,
+		},
+		Report: newPolyphonyReport(),
 	}
 
+	var report = result.Report
+
 	var resultTrack midi.Track
 
-	var time = newMidiTime(int(midiFile.TicksPerQuarter))
+	time, err := newMidiTime(midiFile)
 
-	for _, track := range midiFile.Tracks {
-		for _, event := range track.Events {
-			if event.EventType == midi.ProgramChange {
-				programs[event.Channel] = int(event.FirstParam)
-			} else if event.EventType == midi.MidiOn {
-				time.updateTo(int(event.AbsoluteTime))
-				removeStoppedSounds(noteEndMapping, time.currentMicroSecs)
-				_, sound := getUsedInstrument(bank, programs[event.Channel], event.FirstParam, event.SecondParam)
-
-				if sound != nil {
-					var noteEndTime = noNoteEnd
-
-					if sound.Envelope.DecayVolume == 0 && sound.Envelope.DecayTime >= 0 {
-						noteEndTime = time.currentMicroSecs + int(sound.Envelope.AttackTime+sound.Envelope.DecayTime)
-					}
+	if err != nil {
+		return nil, err
+	}
 
-					var note = noteKeyFromMidi(&programs, event)
-					noteEndMapping[note] = &activeNote{
-						noteEndTime,
-						sound,
-						note,
-						event.Channel,
-					}
+	var events = midi.MergeTracks(midiFile).Tracks[0].Events
+
+	for _, event := range events {
+		if event.EventType == midi.Metadata && event.FirstParam == midi.MetaEnd {
+			break
+		}
+
+		var now = time.microseconds(event.AbsoluteTime)
 
-					if len(noteEndMapping) > maxActive {
-						maxActive = len(noteEndMapping)
+		removeStoppedSounds(noteEndMapping, now, &time, report)
+
+		if event.EventType == midi.ProgramChange {
+			programs[event.Channel] = int(event.FirstParam)
+		} else if event.EventType == midi.ControlChange && event.FirstParam == sustainController {
+			sustain[event.Channel] = event.SecondParam >= 64
+
+			if !sustain[event.Channel] {
+				for _, active := range noteEndMapping {
+					if active.channel == event.Channel && active.sustained {
+						active.sustained = false
+						releaseNote(active, now)
 					}
 				}
-			} else if event.EventType == midi.MidiOff {
-				time.updateTo(int(event.AbsoluteTime))
+			}
+		} else if event.EventType == midi.MidiOn && !midi.IsNoteOff(event) {
+			instrument, sound := getUsedInstrument(bank, programs[event.Channel], event.FirstParam, event.SecondParam)
 
-				var note = noteKeyFromMidi(&programs, event)
+			if sound != nil {
+				var noteEndTime = noNoteEnd
 
-				active, has := noteEndMapping[note]
+				if sound.Envelope != nil && sound.Envelope.DecayVolume == 0 && sound.Envelope.DecayTime >= 0 {
+					noteEndTime = now + int(sound.Envelope.AttackTime+sound.Envelope.DecayTime)
+				}
 
-				if has {
-					active.untilMicroseconds = time.currentMicroSecs + int(active.currentSound.Envelope.ReleaseTime)
+				var note = noteKeyFromMidi(event)
+				var newNote = &activeNote{
+					untilMicroseconds: noteEndTime,
+					currentSound:      sound,
+					key:               note,
+					channel:           event.Channel,
+					priority:          instrument.Priority,
+					velocity:          event.SecondParam,
+					program:           programs[event.Channel],
+					startTick:         event.AbsoluteTime,
+					startMicroseconds: now,
+					released:          false,
+					sustained:         false,
 				}
-			} else if event.EventType == midi.Metadata && event.FirstParam == midi.MetaTempo {
-				fmt.Println("Tempo midi event not currently supported")
-				os.Exit(1)
-			} else if event.EventType == midi.Metadata && event.FirstParam == midi.MetaEnd {
-				time.updateTo(int(event.AbsoluteTime))
-				removeStoppedSounds(noteEndMapping, time.currentMicroSecs)
 
-				var activeCount = 0
+				_, retrigger := noteEndMapping[note]
+
+				if maxActiveSounds != 0 && !retrigger && len(noteEndMapping) >= maxActiveSounds {
+					var victim = newNote
+
+					for _, active := range noteEndMapping {
+						if canStealNote(active, &sustain) && isLessImportant(active, victim) {
+							victim = active
+						}
+					}
+
+					if victim == newNote {
+						skipNoteOff[noteKeyFromMidi(event)]++
+
+						report.Changes = append(report.Changes, VoiceChange{
+							Type:       VOICE_NOTE_REMOVED,
+							Tick:       event.AbsoluteTime,
+							Channel:    event.Channel,
+							Key:        event.FirstParam,
+							ForChannel: event.Channel,
+							ForKey:     event.FirstParam,
+						})
+
+						continue
+					}
+
+					delete(noteEndMapping, victim.key)
+
+					// released notes are already ending so only held notes need to be stopped
+					if !victim.released {
+						skipNoteOff[victim.key]++
 
-				for _, runningNote := range noteEndMapping {
-					if runningNote.untilMicroseconds == noNoteEnd {
 						resultTrack.Events = append(resultTrack.Events, &midi.MidiEvent{
 							AbsoluteTime: event.AbsoluteTime,
 							EventType:    midi.MidiOff,
-							Channel:      runningNote.channel,
-							FirstParam:   uint8(runningNote.key.node),
+							Channel:      victim.channel,
+							FirstParam:   victim.key.node,
 							SecondParam:  0,
 							Metadata:     nil,
 						})
 
-						activeCount = activeCount + 1
+						report.Changes = append(report.Changes, VoiceChange{
+							Type:       VOICE_NOTE_TRUNCATED,
+							Tick:       event.AbsoluteTime,
+							Channel:    victim.channel,
+							Key:        victim.key.node,
+							ForChannel: event.Channel,
+							ForKey:     event.FirstParam,
+						})
 					}
 				}
 
-				fmt.Printf("Notes still active at the end %d\n", activeCount)
+				noteEndMapping[note] = newNote
+				report.record(event.AbsoluteTime, now, noteEndMapping)
+			}
+		} else if midi.IsNoteOff(event) {
+			var note = noteKeyFromMidi(event)
+
+			if skipNoteOff[note] > 0 {
+				skipNoteOff[note]--
+				continue
 			}
 
-			resultTrack.Events = append(resultTrack.Events, event)
+			active, has := noteEndMapping[note]
+
+			if has && sustain[event.Channel] {
+				active.sustained = true
+			} else if has {
+				releaseNote(active, now)
+			}
 		}
+
+		resultTrack.Events = append(resultTrack.Events, event)
 	}
 
-	result.Tracks = []*midi.Track{&resultTrack}
+	var endTime = events[len(events)-1].AbsoluteTime
+
+	removeStoppedSounds(noteEndMapping, time.microseconds(endTime), &time, report)
+	report.finish(endTime, time.microseconds(endTime), noteEndMapping)
+
+	for _, runningNote := range noteEndMapping {
+		if runningNote.untilMicroseconds == noNoteEnd {
+			resultTrack.Events = append(resultTrack.Events, &midi.MidiEvent{
+				AbsoluteTime: endTime,
+				EventType:    midi.MidiOff,
+				Channel:      runningNote.channel,
+				FirstParam:   uint8(runningNote.key.node),
+				SecondParam:  0,
+				Metadata:     nil,
+			})
+		}
+	}
+
+	resultTrack.Events = append(resultTrack.Events, &midi.MidiEvent{
+		AbsoluteTime: endTime,
+		EventType:    midi.Metadata,
+		Channel:      0xF,
+		FirstParam:   midi.MetaEnd,
+		SecondParam:  0,
+		Metadata:     nil,
+	})
+
+	result.Midi.Tracks = []*midi.Track{&resultTrack}
 
-	return &result, maxActive
+	return result, nil
 }
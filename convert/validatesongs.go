@@ -0,0 +1,212 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/lambertjamesd/sfz2n64/al64"
+	"github.com/lambertjamesd/sfz2n64/midi"
+)
+
+// the highest pitch ratio the resampler in the synthesizer can play
+const MaxPitchRatio = 1.99996
+
+const pitchWheelCenter = 0x2000
+
+type SongIssueType int
+
+const (
+	// a note uses a program that isn't in the bank
+	SONG_ISSUE_MISSING_PROGRAM SongIssueType = iota
+	// the key or velocity of a note isn't in the keymap of any sound
+	SONG_ISSUE_NO_SOUND
+	// a note plays a sound at a higher pitch than the synthesizer supports
+	SONG_ISSUE_PITCH_LIMIT
+	// a channel plays percussion but the bank has no percussion instrument
+	SONG_ISSUE_NO_PERCUSSION
+)
+
+func (issueType SongIssueType) MarshalText() ([]byte, error) {
+	switch issueType {
+	case SONG_ISSUE_MISSING_PROGRAM:
+		return []byte("missingProgram"), nil
+	case SONG_ISSUE_NO_SOUND:
+		return []byte("noSound"), nil
+	case SONG_ISSUE_PITCH_LIMIT:
+		return []byte("pitchLimit"), nil
+	}
+
+	return []byte("noPercussion"), nil
+}
+
+// SongIssue is a note that can't be played by the bank
+type SongIssue struct {
+	Song         string        `json:"song"`
+	Type         SongIssueType `json:"type"`
+	Track        int           `json:"track"`
+	Channel      int           `json:"channel"`
+	Tick         uint32        `json:"tick"`
+	Microseconds int           `json:"microseconds"`
+	Program      int           `json:"program"`
+	Key          int           `json:"key"`
+	Velocity     int           `json:"velocity"`
+	// the pitch ratio of the note for SONG_ISSUE_PITCH_LIMIT
+	Pitch float64 `json:"pitch"`
+}
+
+type SongValidationReport struct {
+	SongCount int         `json:"songCount"`
+	Issues    []SongIssue `json:"issues"`
+}
+
+// the pitch ratio of a sound played by the synthesizer running at outputRate
+func soundPitchRatio(sound *al64.ALSound, key uint8, bendCents float64, bankSampleRate uint32, outputRate uint32) float64 {
+	var cents = bendCents
+
+	if sound.KeyMap != nil {
+		cents = cents + float64((int(key)-int(sound.KeyMap.KeyBase))*100+int(int8(sound.KeyMap.Detune)))
+	}
+
+	return math.Pow(2, cents/1200) * float64(bankSampleRate) / float64(outputRate)
+}
+
+// ValidateSong finds every note in a song that can't be played by the bank.
+// Pitch is checked at the start of each note using the current pitch bend.
+// An outputRate of 0 uses the sample rate of the bank
+func ValidateSong(bank *al64.ALBank, name string, midiFile *midi.Midi, outputRate uint32) ([]SongIssue, error) {
+	time, err := newMidiTime(midiFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if outputRate == 0 {
+		outputRate = bank.SampleRate
+	}
+
+	var eventTracks = make(map[*midi.MidiEvent]int)
+
+	for trackIndex, track := range midiFile.Tracks {
+		for _, event := range track.Events {
+			eventTracks[event] = trackIndex
+		}
+	}
+
+	var programs [16]int
+	var pitchWheel [16]int
+	var issues []SongIssue = nil
+
+	programs[9] = percussionChannel
+
+	for channel := range pitchWheel {
+		pitchWheel[channel] = pitchWheelCenter
+	}
+
+	for _, event := range midi.MergeTracks(midiFile).Tracks[0].Events {
+		if event.EventType == midi.ProgramChange {
+			programs[event.Channel] = int(event.FirstParam)
+			continue
+		} else if event.EventType == midi.PitchWheel {
+			pitchWheel[event.Channel] = int(event.FirstParam) | int(event.SecondParam)<<7
+			continue
+		} else if event.EventType != midi.MidiOn || midi.IsNoteOff(event) {
+			continue
+		}
+
+		var program = programs[event.Channel]
+		var issue = SongIssue{
+			Song:         name,
+			Type:         SONG_ISSUE_MISSING_PROGRAM,
+			Track:        eventTracks[event],
+			Channel:      int(event.Channel),
+			Tick:         event.AbsoluteTime,
+			Microseconds: time.microseconds(event.AbsoluteTime),
+			Program:      program,
+			Key:          int(event.FirstParam),
+			Velocity:     int(event.SecondParam),
+			Pitch:        0,
+		}
+
+		if program == percussionChannel && bank.Percussion == nil {
+			issue.Type = SONG_ISSUE_NO_PERCUSSION
+			issues = append(issues, issue)
+			continue
+		} else if program != percussionChannel && !hasInstrument(bank, program) {
+			issues = append(issues, issue)
+			continue
+		}
+
+		instrument, sound := getUsedInstrument(bank, program, event.FirstParam, event.SecondParam)
+
+		if sound == nil {
+			issue.Type = SONG_ISSUE_NO_SOUND
+			issues = append(issues, issue)
+			continue
+		}
+
+		var bendCents = float64(pitchWheel[event.Channel]-pitchWheelCenter) / pitchWheelCenter * float64(instrument.BendRange)
+		var pitch = soundPitchRatio(sound, event.FirstParam, bendCents, bank.SampleRate, outputRate)
+
+		if pitch > MaxPitchRatio {
+			issue.Type = SONG_ISSUE_PITCH_LIMIT
+			issue.Pitch = pitch
+			issues = append(issues, issue)
+		}
+	}
+
+	return issues, nil
+}
+
+func (issue *SongIssue) String() string {
+	var location = fmt.Sprintf(
+		"%s: tick %d (%s) track %d channel %d",
+		issue.Song,
+		issue.Tick,
+		secondsString(issue.Microseconds),
+		issue.Track,
+		issue.Channel,
+	)
+
+	var message string
+
+	switch issue.Type {
+	case SONG_ISSUE_MISSING_PROGRAM:
+		message = fmt.Sprintf("%s is not in the bank", programName(issue.Program))
+	case SONG_ISSUE_NO_SOUND:
+		message = fmt.Sprintf("%s has no sound for key %d velocity %d", programName(issue.Program), issue.Key, issue.Velocity)
+	case SONG_ISSUE_PITCH_LIMIT:
+		message = fmt.Sprintf(
+			"%s key %d plays at %.5f times its pitch which is above the limit of %.5f",
+			programName(issue.Program),
+			issue.Key,
+			issue.Pitch,
+			MaxPitchRatio,
+		)
+	case SONG_ISSUE_NO_PERCUSSION:
+		message = "plays percussion but the bank has no percussion instrument"
+	}
+
+	return fmt.Sprintf("%s: %s", location, message)
+}
+
+func (report *SongValidationReport) Write(out io.Writer) {
+	for _, issue := range report.Issues {
+		fmt.Fprintln(out, issue.String())
+	}
+
+	fmt.Fprintf(out, "%d problems found in %d songs\n", len(report.Issues), report.SongCount)
+}
+
+func (report *SongValidationReport) WriteJSON(out io.Writer) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(append(data, '\n'))
+
+	return err
+}
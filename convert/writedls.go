@@ -0,0 +1,197 @@
+package convert
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/lambertjamesd/sfz2n64/al64"
+	"github.com/lambertjamesd/sfz2n64/audioconvert"
+	"github.com/lambertjamesd/sfz2n64/dls"
+	"github.com/lambertjamesd/sfz2n64/wav"
+)
+
+type dlsWriteState struct {
+	tblData    []byte
+	result     *dls.Dls
+	waves      map[*al64.ALWavetable]uint32
+	sampleRate uint32
+}
+
+func dlsSecondsToTime(seconds float64) int32 {
+	if seconds <= 0 {
+		return dls.CONN_TIME_ZERO
+	}
+
+	return int32(math.Round(1200 * math.Log2(seconds) * dls.CONN_SCALE))
+}
+
+// combined pan of the instrument and sound from -1 to 1 for dls and
+// sf2. Both pans are centered at 64 so a sound panned all the way to
+// one side stays panned all the way after a round trip
+func fullSoundPan(inst *al64.ALInstrument, sound *al64.ALSound) float64 {
+	return scaledSoundPan(inst, sound, 64)
+}
+
+func dlsConnection(destination uint16, scale int32) dls.Connection {
+	return dls.Connection{
+		Source:      dls.CONN_SRC_NONE,
+		Control:     dls.CONN_SRC_NONE,
+		Destination: destination,
+		Transform:   0,
+		Scale:       scale,
+	}
+}
+
+func (state *dlsWriteState) addWave(wave *al64.ALWavetable, name string) uint32 {
+	existing, ok := state.waves[wave]
+
+	if ok {
+		return existing
+	}
+
+	var data = state.tblData[wave.Base : wave.Base+wave.Len]
+	var samples = audioconvert.DecodeWavetable(wave, data, state.sampleRate)
+
+	var result = &dls.Wave{
+		Name: name,
+		Format: wav.WaveHeader{
+			Format:        wav.FORMAT_PCM,
+			NChannels:     1,
+			SampleRate:    state.sampleRate,
+			ByteRate:      state.sampleRate * 2,
+			BlockAlign:    2,
+			BitsPerSample: 16,
+		},
+		WaveSample: nil,
+		Data:       audioconvert.EncodeSamples(samples, binary.LittleEndian),
+	}
+
+	var index = uint32(len(state.result.Waves))
+	state.result.Waves = append(state.result.Waves, result)
+	state.waves[wave] = index
+
+	return index
+}
+
+func (state *dlsWriteState) soundRegion(inst *al64.ALInstrument, sound *al64.ALSound, name string) *dls.Region {
+	var waveSample = &dls.WaveSample{
+		UnityNote:   uint16(sound.KeyMap.KeyBase),
+		FineTune:    int16(int8(sound.KeyMap.Detune)),
+		Attenuation: int32(math.Round(volumeScaleToDecibels(soundVolumeScale(inst, sound)) * 10 * dls.CONN_SCALE)),
+		Options:     0,
+		Loops:       nil,
+	}
+
+	loopStart, loopEnd, hasLoop := wavetableLoop(sound.Wavetable)
+
+	if hasLoop {
+		waveSample.Loops = append(waveSample.Loops, dls.Loop{
+			Type:   dls.WLOOP_TYPE_FORWARD,
+			Start:  loopStart,
+			Length: loopEnd - loopStart,
+		})
+	}
+
+	var articulation = []dls.Connection{
+		dlsConnection(dls.CONN_DST_PAN, int32(math.Round(fullSoundPan(inst, sound)*500*dls.CONN_SCALE))),
+	}
+
+	if sound.Envelope != nil {
+		var sustainLevel float64 = 1000
+
+		if sound.Envelope.AttackVolume != 0 {
+			sustainLevel = 1000 * float64(sound.Envelope.DecayVolume) / float64(sound.Envelope.AttackVolume)
+		}
+
+		articulation = append(articulation,
+			dlsConnection(dls.CONN_DST_EG1_ATTACKTIME, dlsSecondsToTime(float64(sound.Envelope.AttackTime)/1000000)),
+			dlsConnection(dls.CONN_DST_EG1_DECAYTIME, dlsSecondsToTime(float64(sound.Envelope.DecayTime)/1000000)),
+			dlsConnection(dls.CONN_DST_EG1_SUSTAINLEVEL, int32(math.Round(sustainLevel*dls.CONN_SCALE))),
+			dlsConnection(dls.CONN_DST_EG1_RELEASETIME, dlsSecondsToTime(float64(sound.Envelope.ReleaseTime)/1000000)),
+		)
+	}
+
+	return &dls.Region{
+		KeyLow:       uint16(sound.KeyMap.KeyMin),
+		KeyHigh:      uint16(sound.KeyMap.KeyMax),
+		VelocityLow:  uint16(sound.KeyMap.VelocityMin),
+		VelocityHigh: uint16(sound.KeyMap.VelocityMax),
+		Options:      0,
+		KeyGroup:     0,
+		WaveSample:   waveSample,
+		WaveLink: dls.WaveLink{
+			Options:    0,
+			PhaseGroup: 0,
+			Channel:    1,
+			TableIndex: state.addWave(sound.Wavetable, name),
+		},
+		Articulation: articulation,
+	}
+}
+
+func (state *dlsWriteState) addInstrument(inst *al64.ALInstrument, name string, bank uint32, program uint32) {
+	var result = &dls.Instrument{
+		Name:         name,
+		Bank:         bank,
+		Program:      program,
+		Regions:      nil,
+		Articulation: nil,
+	}
+
+	for _, sound := range inst.SoundArray {
+		if sound == nil || sound.KeyMap == nil || sound.Wavetable == nil {
+			continue
+		}
+
+		result.Regions = append(result.Regions, state.soundRegion(inst, sound, name))
+	}
+
+	state.result.Instruments = append(state.result.Instruments, result)
+}
+
+// WriteDlsFile writes all of the banks into a single dls file. Each
+// ALBank uses the bank number matching its index and the percussion
+// instrument is written as a drum instrument in the same bank
+func WriteDlsFile(bankFile *al64.ALBankFile, tblData []byte, filename string) error {
+	var name = filepath.Base(filename)
+	name = name[0 : len(name)-len(filepath.Ext(name))]
+
+	var state = dlsWriteState{
+		tblData: tblData,
+		result:  &dls.Dls{Name: name},
+		waves:   make(map[*al64.ALWavetable]uint32),
+	}
+
+	for bankIndex, bank := range bankFile.BankArray {
+		state.sampleRate = bank.SampleRate
+
+		for program, inst := range bank.InstArray {
+			if inst != nil {
+				var instName = fmt.Sprintf("Instrument %d", program)
+
+				if program < len(MIDINames) {
+					instName = MIDINames[program]
+				}
+
+				state.addInstrument(inst, instName, dls.BankFromNumber(uint16(bankIndex), false), uint32(program))
+			}
+		}
+
+		if bank.Percussion != nil {
+			state.addInstrument(bank.Percussion, "Percussion", dls.BankFromNumber(uint16(bankIndex), true), 0)
+		}
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return state.result.Serialize(file)
+}
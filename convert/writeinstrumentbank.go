@@ -23,7 +23,7 @@ func writeWavetable(state *insConversionState, source interface{}, output *os.Fi
 		var name = "." + string(filepath.Separator) + "sounds" + string(filepath.Separator) + state.getUniqueName(".aifc")
 		var err = audioconvert.WriteAifc(filepath.Join(state.cwd, name), wave, data, state.sampleRate)
 
-		err = audioconvert.WriteWav(filepath.Join(state.cwd, name[0:len(name)-4]+"wav"), wave, data, state.sampleRate)
+		err = audioconvert.WriteWav(filepath.Join(state.cwd, name[0:len(name)-4]+"wav"), wave, data, state.sampleRate, state.keyMap)
 
 		return name, err
 	} else {
@@ -118,6 +118,7 @@ func writeSound(state *insConversionState, source interface{}, output *os.File)
 	}
 
 	if sound.Wavetable != nil {
+		state.keyMap = sound.KeyMap
 		soundName, err = state.writeSection(sound.Wavetable, output, state.nameHint+"Snd", writeWavetable)
 
 		if err != nil {
@@ -0,0 +1,261 @@
+package convert
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/lambertjamesd/sfz2n64/al64"
+	"github.com/lambertjamesd/sfz2n64/audioconvert"
+	"github.com/lambertjamesd/sfz2n64/sf2"
+)
+
+// the sf2 spec requires at least 46 zero samples after each sample
+const sf2SamplePadding = 46
+
+// longest envelope time allowed by the sf2 spec
+const sf2MaxTimecents = 8000
+
+type sf2WriteState struct {
+	tblData     []byte
+	result      *sf2.Sf2File
+	samples     map[*al64.ALWavetable]uint16
+	instruments map[*al64.ALInstrument]uint16
+	sampleRate  uint32
+}
+
+func sf2Name(name string) string {
+	if len(name) >= sf2.NAME_LENGTH {
+		return name[0 : sf2.NAME_LENGTH-1]
+	}
+
+	return name
+}
+
+func sf2MicrosecondsToTimecents(microseconds int32) uint16 {
+	var result = sf2MinTimecents
+
+	if microseconds > 0 {
+		result = int(math.Round(1200 * math.Log2(float64(microseconds)/1000000)))
+	}
+
+	return uint16(int16(clampInt(result, sf2MinTimecents, sf2MaxTimecents)))
+}
+
+func sf2VolumeToCentibels(volumeScale float64) int {
+	if volumeScale <= 0 {
+		return 1440
+	} else if volumeScale >= 1 {
+		return 0
+	}
+
+	return clampInt(int(math.Round(-200*math.Log10(volumeScale))), 0, 1440)
+}
+
+func (state *sf2WriteState) addSample(wave *al64.ALWavetable, name string) uint16 {
+	existing, ok := state.samples[wave]
+
+	if ok {
+		return existing
+	}
+
+	var data = state.tblData[wave.Base : wave.Base+wave.Len]
+	var samples = audioconvert.DecodeWavetable(wave, data, state.sampleRate)
+
+	var start = uint32(len(state.result.SampleData))
+	var end = start + uint32(len(samples))
+
+	var sample = &sf2.Sample{
+		Name:            sf2Name(name),
+		Start:           start,
+		End:             end,
+		StartLoop:       start,
+		EndLoop:         end,
+		SampleRate:      state.sampleRate,
+		OriginalPitch:   60,
+		PitchCorrection: 0,
+		SampleLink:      0,
+		SampleType:      sf2.MonoSample,
+	}
+
+	var loopStart, loopEnd, hasLoop = wavetableLoop(wave)
+
+	if hasLoop {
+		sample.StartLoop = start + loopStart
+		sample.EndLoop = start + loopEnd
+
+		if sample.EndLoop > end {
+			sample.EndLoop = end
+		}
+	}
+
+	state.result.SampleData = append(state.result.SampleData, samples...)
+	state.result.SampleData = append(state.result.SampleData, make([]int16, sf2SamplePadding)...)
+
+	var index = uint16(len(state.result.Samples))
+	state.result.Samples = append(state.result.Samples, sample)
+	state.samples[wave] = index
+
+	return index
+}
+
+func wavetableLoop(wave *al64.ALWavetable) (uint32, uint32, bool) {
+	if wave.Type == al64.AL_ADPCM_WAVE && wave.AdpcWave.Loop != nil && wave.AdpcWave.Loop.End > wave.AdpcWave.Loop.Start {
+		return wave.AdpcWave.Loop.Start, wave.AdpcWave.Loop.End, true
+	} else if wave.Type == al64.AL_RAW16_WAVE && wave.RawWave.Loop != nil && wave.RawWave.Loop.End > wave.RawWave.Loop.Start {
+		return wave.RawWave.Loop.Start, wave.RawWave.Loop.End, true
+	}
+
+	return 0, 0, false
+}
+
+func (state *sf2WriteState) soundZone(inst *al64.ALInstrument, sound *al64.ALSound, name string) *sf2.Zone {
+	var zone sf2.Zone
+
+	// key and velocity ranges have to be the first generators
+	zone.Generators = append(zone.Generators,
+		sf2.Generator{Operator: sf2.GenKeyRange, Amount: sf2.RangeAmount(sound.KeyMap.KeyMin, sound.KeyMap.KeyMax)},
+		sf2.Generator{Operator: sf2.GenVelRange, Amount: sf2.RangeAmount(sound.KeyMap.VelocityMin, sound.KeyMap.VelocityMax)},
+	)
+
+	var pan = int(math.Round(fullSoundPan(inst, sound) * 500))
+	zone.Generators = append(zone.Generators, sf2.Generator{Operator: sf2.GenPan, Amount: uint16(int16(pan))})
+
+	var volumeScale = soundVolumeScale(inst, sound)
+	zone.Generators = append(zone.Generators, sf2.Generator{Operator: sf2.GenInitialAttenuation, Amount: uint16(sf2VolumeToCentibels(volumeScale))})
+
+	if sound.Envelope != nil {
+		var sustain = 0
+
+		if sound.Envelope.AttackVolume != 0 {
+			sustain = sf2VolumeToCentibels(float64(sound.Envelope.DecayVolume) / float64(sound.Envelope.AttackVolume))
+		}
+
+		// the soundfont decay is the time to reach silence instead of the sustain level
+		var decayTime = sound.Envelope.DecayTime
+
+		if sustain > 0 && sustain < 1000 {
+			decayTime = int32(int64(decayTime) * 1000 / int64(sustain))
+		}
+
+		zone.Generators = append(zone.Generators,
+			sf2.Generator{Operator: sf2.GenAttackVolEnv, Amount: sf2MicrosecondsToTimecents(sound.Envelope.AttackTime)},
+			sf2.Generator{Operator: sf2.GenDecayVolEnv, Amount: sf2MicrosecondsToTimecents(decayTime)},
+			sf2.Generator{Operator: sf2.GenSustainVolEnv, Amount: uint16(sustain)},
+			sf2.Generator{Operator: sf2.GenReleaseVolEnv, Amount: sf2MicrosecondsToTimecents(sound.Envelope.ReleaseTime)},
+		)
+	}
+
+	var detune = int8(sound.KeyMap.Detune)
+
+	if detune != 0 {
+		zone.Generators = append(zone.Generators, sf2.Generator{Operator: sf2.GenFineTune, Amount: uint16(int16(detune))})
+	}
+
+	_, _, hasLoop := wavetableLoop(sound.Wavetable)
+
+	if hasLoop {
+		zone.Generators = append(zone.Generators, sf2.Generator{Operator: sf2.GenSampleModes, Amount: sf2.LoopContinuous})
+	}
+
+	zone.Generators = append(zone.Generators,
+		sf2.Generator{Operator: sf2.GenOverridingRootKey, Amount: uint16(sound.KeyMap.KeyBase)},
+		sf2.Generator{Operator: sf2.GenSampleID, Amount: state.addSample(sound.Wavetable, name)},
+	)
+
+	return &zone
+}
+
+func (state *sf2WriteState) addInstrument(inst *al64.ALInstrument, name string) uint16 {
+	existing, ok := state.instruments[inst]
+
+	if ok {
+		return existing
+	}
+
+	var instrument = &sf2.Instrument{
+		Name:       sf2Name(name),
+		GlobalZone: nil,
+		Zones:      nil,
+	}
+
+	for _, sound := range inst.SoundArray {
+		if sound == nil || sound.KeyMap == nil || sound.Wavetable == nil {
+			continue
+		}
+
+		instrument.Zones = append(instrument.Zones, state.soundZone(inst, sound, name))
+	}
+
+	var index = uint16(len(state.result.Instruments))
+	state.result.Instruments = append(state.result.Instruments, instrument)
+	state.instruments[inst] = index
+
+	return index
+}
+
+func (state *sf2WriteState) addPreset(inst *al64.ALInstrument, name string, bank uint16, preset uint16) {
+	var instrumentIndex = state.addInstrument(inst, name)
+
+	state.result.Presets = append(state.result.Presets, &sf2.Preset{
+		Name:       sf2Name(name),
+		Preset:     preset,
+		Bank:       bank,
+		GlobalZone: nil,
+		Zones: []*sf2.Zone{&sf2.Zone{
+			Generators: []sf2.Generator{sf2.Generator{Operator: sf2.GenInstrument, Amount: instrumentIndex}},
+			Modulators: nil,
+		}},
+	})
+}
+
+// WriteSf2File writes all of the banks into a single soundfont. Each
+// ALBank uses the bank number matching its index and the program number
+// as the preset number. The percussion instrument of each bank is placed
+// in bank 128 with the preset number matching the bank index
+func WriteSf2File(bankFile *al64.ALBankFile, tblData []byte, filename string) error {
+	var name = filepath.Base(filename)
+	name = name[0 : len(name)-len(filepath.Ext(name))]
+
+	var state = sf2WriteState{
+		tblData: tblData,
+		result: &sf2.Sf2File{
+			Version:     sf2.Version{Major: 2, Minor: 1},
+			SoundEngine: "EMU8000",
+			Name:        name,
+		},
+		samples:     make(map[*al64.ALWavetable]uint16),
+		instruments: make(map[*al64.ALInstrument]uint16),
+	}
+
+	for bankIndex, bank := range bankFile.BankArray {
+		state.sampleRate = bank.SampleRate
+
+		for program, inst := range bank.InstArray {
+			if inst != nil {
+				var instName = fmt.Sprintf("Instrument %d", program)
+
+				if program < len(MIDINames) {
+					instName = MIDINames[program]
+				}
+
+				state.addPreset(inst, instName, uint16(bankIndex), uint16(program))
+			}
+		}
+
+		if bank.Percussion != nil {
+			state.addPreset(bank.Percussion, "Percussion", sf2.PERCUSSION_BANK, uint16(bankIndex))
+		}
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return state.result.Serialize(file)
+}
@@ -21,7 +21,7 @@ func writeSfzWavetable(state *insConversionState, source interface{}, output *os
 	var data = state.tblData[wave.Base : wave.Base+wave.Len]
 
 	var name = "." + string(filepath.Separator) + "sounds" + string(filepath.Separator) + state.getUniqueName(".wav")
-	var err = audioconvert.WriteWav(filepath.Join(state.cwd, name), wave, data, state.sampleRate)
+	var err = audioconvert.WriteWav(filepath.Join(state.cwd, name), wave, data, state.sampleRate, state.keyMap)
 	return name, err
 }
 
@@ -70,6 +70,44 @@ loop_end=%d
 `, start, end))
 }
 
+// combined pan of the instrument and sound from -1 to 1
+func soundPan(inst *al64.ALInstrument, sound *al64.ALSound) float64 {
+	return scaledSoundPan(inst, sound, 128)
+}
+
+// combined pan of the instrument and sound divided by scale and
+// clamped from -1 to 1
+func scaledSoundPan(inst *al64.ALInstrument, sound *al64.ALSound, scale float64) float64 {
+	var result = (float64(inst.Pan) + float64(sound.SamplePan) - 128) / scale
+
+	if result > 1 {
+		return 1
+	} else if result < -1 {
+		return -1
+	}
+
+	return result
+}
+
+// combined volume of the instrument, sound, and envelope from 0 to 1
+func soundVolumeScale(inst *al64.ALInstrument, sound *al64.ALSound) float64 {
+	var result = float64(int(inst.Volume)*int(sound.SampleVolume)) / (127 * 127)
+
+	if sound.Envelope != nil {
+		result *= float64(sound.Envelope.AttackVolume) / 127
+	}
+
+	return result
+}
+
+func volumeScaleToDecibels(volumeScale float64) float64 {
+	if volumeScale <= 0 {
+		return -144
+	}
+
+	return math.Log(volumeScale) / math.Log(1.071773463)
+}
+
 func writeSfzInstrument(state *insConversionState, source interface{}, output *os.File) (string, error) {
 	inst, ok := source.(*al64.ALInstrument)
 
@@ -94,19 +132,14 @@ func writeSfzInstrument(state *insConversionState, source interface{}, output *o
 	for _, sound := range inst.SoundArray {
 		instFile.WriteString("\n<region>\n")
 
+		state.keyMap = sound.KeyMap
 		soundName, err := state.writeSection(sound.Wavetable, instFile, state.nameHint+"Snd", writeSfzWavetable)
 
 		if err != nil {
 			return "", err
 		}
 
-		var sfzPan = (float64(inst.Pan) + float64(sound.SamplePan) - 128) / 128
-
-		if sfzPan > 1 {
-			sfzPan = 1
-		} else if sfzPan < -1 {
-			sfzPan = -1
-		}
+		var sfzPan = soundPan(inst, sound)
 
 		if sfzPan != 0 {
 			instFile.WriteString(fmt.Sprintf("pan=%.06f\n", sfzPan*100))
@@ -114,18 +147,10 @@ func writeSfzInstrument(state *insConversionState, source interface{}, output *o
 
 		instFile.WriteString(fmt.Sprintf("sample=.%s\n", soundName))
 
-		var volumeScale = float64(int(inst.Volume)*int(sound.SampleVolume)) / (127 * 127)
-
-		if sound.Envelope != nil {
-			volumeScale *= float64(sound.Envelope.AttackVolume) / 127
-		}
+		var volumeScale = soundVolumeScale(inst, sound)
 
 		if volumeScale < 1 {
-			if volumeScale == 0 {
-				instFile.WriteString(fmt.Sprintf("volume=-144\n"))
-			} else {
-				instFile.WriteString(fmt.Sprintf("volume=%.06f\n", math.Log(volumeScale)/math.Log(1.071773463)))
-			}
+			instFile.WriteString(fmt.Sprintf("volume=%.06f\n", volumeScaleToDecibels(volumeScale)))
 		}
 
 		writeSfzKeyMap(sound.KeyMap, instFile)
@@ -8,11 +8,11 @@ import (
 	"github.com/lambertjamesd/sfz2n64/audioconvert"
 )
 
-func WriteSoundBank(outputName string, inputSounds []string, compressionSettings *adpcm.CompressionSettings) error {
+func WriteSoundBank(outputName string, inputSounds []string, compressionSettings *adpcm.CompressionSettings, pcmSettings *audioconvert.PCMConversionSettings) error {
 	var sounds []*al64.ALSound
 
 	for _, input := range inputSounds {
-		sound, err := audioconvert.ReadWavetable(input)
+		sound, err := audioconvert.ReadWavetable(input, pcmSettings)
 
 		if err != nil {
 			return err
@@ -0,0 +1,435 @@
+package dls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+type chunkCallback func(id uint32, data []byte, offset int) error
+
+// calls callback for each chunk in data with the offset of the chunk header
+func forEachChunk(data []byte, callback chunkCallback) error {
+	var offset = 0
+
+	for offset+8 <= len(data) {
+		var id = binary.BigEndian.Uint32(data[offset:])
+		var size = int(binary.LittleEndian.Uint32(data[offset+4:]))
+
+		if offset+8+size > len(data) {
+			return errors.New(fmt.Sprintf("Chunk %X extends past the end of its parent", id))
+		}
+
+		err := callback(id, data[offset+8:offset+8+size], offset)
+
+		if err != nil {
+			return err
+		}
+
+		offset = offset + 8 + size + (size & 1)
+	}
+
+	return nil
+}
+
+// calls callback for each list with a matching type inside of data
+func forEachList(data []byte, listType uint32, callback func(data []byte) error) error {
+	return forEachChunk(data, func(id uint32, chunkData []byte, offset int) error {
+		if id == LIST_HEADER && len(chunkData) >= 4 && binary.BigEndian.Uint32(chunkData) == listType {
+			return callback(chunkData[4:])
+		}
+
+		return nil
+	})
+}
+
+func parseName(data []byte) string {
+	var result = ""
+
+	forEachList(data, INFO, func(info []byte) error {
+		return forEachChunk(info, func(id uint32, chunkData []byte, offset int) error {
+			if id == INAM {
+				var end = bytes.IndexByte(chunkData, 0)
+
+				if end == -1 {
+					end = len(chunkData)
+				}
+
+				result = strings.TrimSpace(string(chunkData[0:end]))
+			}
+
+			return nil
+		})
+	})
+
+	return result
+}
+
+func parseWaveSample(data []byte) (*WaveSample, error) {
+	if len(data) < 20 {
+		return nil, errors.New("wsmp chunk is too small")
+	}
+
+	var size = int(binary.LittleEndian.Uint32(data[0:]))
+	var result WaveSample
+
+	result.UnityNote = binary.LittleEndian.Uint16(data[4:])
+	result.FineTune = int16(binary.LittleEndian.Uint16(data[6:]))
+	result.Attenuation = int32(binary.LittleEndian.Uint32(data[8:]))
+	result.Options = binary.LittleEndian.Uint32(data[12:])
+	var loopCount = int(binary.LittleEndian.Uint32(data[16:]))
+
+	for index := 0; index < loopCount; index = index + 1 {
+		var loopOffset = size + index*16
+
+		if loopOffset+16 > len(data) {
+			return nil, errors.New("wsmp chunk is too small for its loops")
+		}
+
+		result.Loops = append(result.Loops, Loop{
+			Type:   binary.LittleEndian.Uint32(data[loopOffset+4:]),
+			Start:  binary.LittleEndian.Uint32(data[loopOffset+8:]),
+			Length: binary.LittleEndian.Uint32(data[loopOffset+12:]),
+		})
+	}
+
+	return &result, nil
+}
+
+// reads the connections from either a lart or lar2 list
+func parseArticulation(data []byte) ([]Connection, error) {
+	var result []Connection = nil
+	var hasArticulation = false
+
+	var parseList = func(listData []byte) error {
+		return forEachChunk(listData, func(id uint32, chunkData []byte, offset int) error {
+			if id != ART1 && id != ART2 {
+				return nil
+			}
+
+			if len(chunkData) < 8 {
+				return errors.New("Articulation chunk is too small")
+			}
+
+			hasArticulation = true
+
+			var size = int(binary.LittleEndian.Uint32(chunkData[0:]))
+			var count = int(binary.LittleEndian.Uint32(chunkData[4:]))
+
+			for index := 0; index < count; index = index + 1 {
+				var connectionOffset = size + index*12
+
+				if connectionOffset+12 > len(chunkData) {
+					return errors.New("Articulation chunk is too small for its connections")
+				}
+
+				result = append(result, Connection{
+					Source:      binary.LittleEndian.Uint16(chunkData[connectionOffset:]),
+					Control:     binary.LittleEndian.Uint16(chunkData[connectionOffset+2:]),
+					Destination: binary.LittleEndian.Uint16(chunkData[connectionOffset+4:]),
+					Transform:   binary.LittleEndian.Uint16(chunkData[connectionOffset+6:]),
+					Scale:       int32(binary.LittleEndian.Uint32(chunkData[connectionOffset+8:])),
+				})
+			}
+
+			return nil
+		})
+	}
+
+	err := forEachList(data, LART, parseList)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = forEachList(data, LAR2, parseList)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if hasArticulation && result == nil {
+		result = []Connection{}
+	}
+
+	return result, nil
+}
+
+func parseRegion(data []byte) (*Region, error) {
+	var result Region
+	var hasHeader = false
+	var hasLink = false
+
+	err := forEachChunk(data, func(id uint32, chunkData []byte, offset int) error {
+		if id == RGNH {
+			if len(chunkData) < 12 {
+				return errors.New("rgnh chunk is too small")
+			}
+
+			result.KeyLow = binary.LittleEndian.Uint16(chunkData[0:])
+			result.KeyHigh = binary.LittleEndian.Uint16(chunkData[2:])
+			result.VelocityLow = binary.LittleEndian.Uint16(chunkData[4:])
+			result.VelocityHigh = binary.LittleEndian.Uint16(chunkData[6:])
+			result.Options = binary.LittleEndian.Uint16(chunkData[8:])
+			result.KeyGroup = binary.LittleEndian.Uint16(chunkData[10:])
+			hasHeader = true
+		} else if id == WSMP {
+			waveSample, err := parseWaveSample(chunkData)
+
+			if err != nil {
+				return err
+			}
+
+			result.WaveSample = waveSample
+		} else if id == WLNK {
+			if len(chunkData) < 12 {
+				return errors.New("wlnk chunk is too small")
+			}
+
+			result.WaveLink.Options = binary.LittleEndian.Uint16(chunkData[0:])
+			result.WaveLink.PhaseGroup = binary.LittleEndian.Uint16(chunkData[2:])
+			result.WaveLink.Channel = binary.LittleEndian.Uint32(chunkData[4:])
+			result.WaveLink.TableIndex = binary.LittleEndian.Uint32(chunkData[8:])
+			hasLink = true
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasHeader || !hasLink {
+		return nil, errors.New("Region is missing a rgnh or wlnk chunk")
+	}
+
+	result.Articulation, err = parseArticulation(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func parseInstrument(data []byte) (*Instrument, error) {
+	var result Instrument
+	var hasHeader = false
+
+	err := forEachChunk(data, func(id uint32, chunkData []byte, offset int) error {
+		if id == INSH {
+			if len(chunkData) < 12 {
+				return errors.New("insh chunk is too small")
+			}
+
+			result.Bank = binary.LittleEndian.Uint32(chunkData[4:])
+			result.Program = binary.LittleEndian.Uint32(chunkData[8:])
+			hasHeader = true
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasHeader {
+		return nil, errors.New("Instrument is missing an insh chunk")
+	}
+
+	result.Name = parseName(data)
+
+	err = forEachList(data, LRGN, func(regions []byte) error {
+		var parseRegionList = func(regionData []byte) error {
+			region, err := parseRegion(regionData)
+
+			if err != nil {
+				return err
+			}
+
+			result.Regions = append(result.Regions, region)
+			return nil
+		}
+
+		err := forEachList(regions, RGN, parseRegionList)
+
+		if err != nil {
+			return err
+		}
+
+		return forEachList(regions, RGN2, parseRegionList)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.Articulation, err = parseArticulation(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func parseWave(data []byte) (*Wave, error) {
+	var result Wave
+	var hasFormat = false
+	var hasData = false
+
+	err := forEachChunk(data, func(id uint32, chunkData []byte, offset int) error {
+		if id == FMT {
+			if len(chunkData) < 16 {
+				return errors.New("fmt chunk is too small")
+			}
+
+			result.Format.Format = binary.LittleEndian.Uint16(chunkData[0:])
+			result.Format.NChannels = binary.LittleEndian.Uint16(chunkData[2:])
+			result.Format.SampleRate = binary.LittleEndian.Uint32(chunkData[4:])
+			result.Format.ByteRate = binary.LittleEndian.Uint32(chunkData[8:])
+			result.Format.BlockAlign = binary.LittleEndian.Uint16(chunkData[12:])
+			result.Format.BitsPerSample = binary.LittleEndian.Uint16(chunkData[14:])
+			hasFormat = true
+		} else if id == DATA {
+			result.Data = append([]byte(nil), chunkData...)
+			hasData = true
+		} else if id == WSMP {
+			waveSample, err := parseWaveSample(chunkData)
+
+			if err != nil {
+				return err
+			}
+
+			result.WaveSample = waveSample
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasFormat || !hasData {
+		return nil, errors.New("Wave is missing a fmt or data chunk")
+	}
+
+	result.Name = parseName(data)
+
+	return &result, nil
+}
+
+func Parse(reader io.Reader) (*Dls, error) {
+	data, err := ioutil.ReadAll(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 12 || binary.BigEndian.Uint32(data) != RIFF_HEADER {
+		return nil, errors.New("Invalid dls header")
+	}
+
+	if binary.BigEndian.Uint32(data[8:]) != DLS_FORMAT {
+		return nil, errors.New("Invalid dls format")
+	}
+
+	var size = int(binary.LittleEndian.Uint32(data[4:]))
+
+	if size+8 > len(data) {
+		size = len(data) - 8
+	}
+
+	var content = data[12 : size+8]
+
+	var result Dls
+	var cueOffsets []uint32 = nil
+	var waveOffsets = make(map[uint32]int)
+
+	err = forEachChunk(content, func(id uint32, chunkData []byte, offset int) error {
+		if id == PTBL {
+			if len(chunkData) < 8 {
+				return errors.New("ptbl chunk is too small")
+			}
+
+			var headerSize = int(binary.LittleEndian.Uint32(chunkData[0:]))
+			var cueCount = int(binary.LittleEndian.Uint32(chunkData[4:]))
+
+			if headerSize+cueCount*4 > len(chunkData) {
+				return errors.New("ptbl chunk is too small for its cues")
+			}
+
+			for index := 0; index < cueCount; index = index + 1 {
+				cueOffsets = append(cueOffsets, binary.LittleEndian.Uint32(chunkData[headerSize+index*4:]))
+			}
+		} else if id == LIST_HEADER && len(chunkData) >= 4 && binary.BigEndian.Uint32(chunkData) == WVPL {
+			return forEachChunk(chunkData[4:], func(id uint32, waveData []byte, waveOffset int) error {
+				if id != LIST_HEADER || len(waveData) < 4 || binary.BigEndian.Uint32(waveData) != WAVE {
+					return nil
+				}
+
+				wave, err := parseWave(waveData[4:])
+
+				if err != nil {
+					return err
+				}
+
+				waveOffsets[uint32(waveOffset)] = len(result.Waves)
+				result.Waves = append(result.Waves, wave)
+				return nil
+			})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = forEachList(content, LINS, func(instruments []byte) error {
+		return forEachList(instruments, INS, func(instrumentData []byte) error {
+			instrument, err := parseInstrument(instrumentData)
+
+			if err != nil {
+				return err
+			}
+
+			result.Instruments = append(result.Instruments, instrument)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// wave links point to cues in the pool table which are
+	// replaced with the index of the wave they point to
+	for _, instrument := range result.Instruments {
+		for _, region := range instrument.Regions {
+			if int(region.WaveLink.TableIndex) >= len(cueOffsets) {
+				return nil, errors.New(fmt.Sprintf("Instrument %s uses an invalid wave", instrument.Name))
+			}
+
+			waveIndex, ok := waveOffsets[cueOffsets[region.WaveLink.TableIndex]]
+
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Instrument %s uses a wave that could not be found", instrument.Name))
+			}
+
+			region.WaveLink.TableIndex = uint32(waveIndex)
+		}
+	}
+
+	result.Name = parseName(content)
+
+	return &result, nil
+}
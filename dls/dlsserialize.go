@@ -0,0 +1,180 @@
+package dls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+func writeChunk(out *bytes.Buffer, id uint32, data []byte) {
+	binary.Write(out, binary.BigEndian, &id)
+	var size = uint32(len(data))
+	binary.Write(out, binary.LittleEndian, &size)
+	out.Write(data)
+
+	if len(data)&1 != 0 {
+		out.WriteByte(0)
+	}
+}
+
+func writeList(out *bytes.Buffer, listType uint32, content []byte) {
+	var data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, &listType)
+	data.Write(content)
+	writeChunk(out, LIST_HEADER, data.Bytes())
+}
+
+func writeName(out *bytes.Buffer, name string) {
+	if name == "" {
+		return
+	}
+
+	var info bytes.Buffer
+	writeChunk(&info, INAM, append([]byte(name), 0))
+	writeList(out, INFO, info.Bytes())
+}
+
+func writeLE(out *bytes.Buffer, values ...interface{}) {
+	for _, value := range values {
+		binary.Write(out, binary.LittleEndian, value)
+	}
+}
+
+func writeWaveSample(out *bytes.Buffer, waveSample *WaveSample) {
+	if waveSample == nil {
+		return
+	}
+
+	var data bytes.Buffer
+	writeLE(&data, uint32(20), waveSample.UnityNote, waveSample.FineTune, waveSample.Attenuation, waveSample.Options, uint32(len(waveSample.Loops)))
+
+	for _, loop := range waveSample.Loops {
+		writeLE(&data, uint32(16), loop.Type, loop.Start, loop.Length)
+	}
+
+	writeChunk(out, WSMP, data.Bytes())
+}
+
+func writeArticulation(out *bytes.Buffer, connections []Connection) {
+	if connections == nil {
+		return
+	}
+
+	var data bytes.Buffer
+	writeLE(&data, uint32(8), uint32(len(connections)))
+
+	for _, connection := range connections {
+		writeLE(&data, connection.Source, connection.Control, connection.Destination, connection.Transform, connection.Scale)
+	}
+
+	var list bytes.Buffer
+	writeChunk(&list, ART1, data.Bytes())
+	writeList(out, LART, list.Bytes())
+}
+
+func writeRegion(out *bytes.Buffer, region *Region) {
+	var content bytes.Buffer
+
+	var header bytes.Buffer
+	writeLE(&header, region.KeyLow, region.KeyHigh, region.VelocityLow, region.VelocityHigh, region.Options, region.KeyGroup)
+	writeChunk(&content, RGNH, header.Bytes())
+
+	writeWaveSample(&content, region.WaveSample)
+
+	var link bytes.Buffer
+	writeLE(&link, region.WaveLink.Options, region.WaveLink.PhaseGroup, region.WaveLink.Channel, region.WaveLink.TableIndex)
+	writeChunk(&content, WLNK, link.Bytes())
+
+	writeArticulation(&content, region.Articulation)
+
+	writeList(out, RGN, content.Bytes())
+}
+
+func writeInstrument(out *bytes.Buffer, instrument *Instrument) {
+	var content bytes.Buffer
+
+	var header bytes.Buffer
+	writeLE(&header, uint32(len(instrument.Regions)), instrument.Bank, instrument.Program)
+	writeChunk(&content, INSH, header.Bytes())
+
+	var regions bytes.Buffer
+
+	for _, region := range instrument.Regions {
+		writeRegion(&regions, region)
+	}
+
+	writeList(&content, LRGN, regions.Bytes())
+	writeArticulation(&content, instrument.Articulation)
+	writeName(&content, instrument.Name)
+
+	writeList(out, INS, content.Bytes())
+}
+
+func writeWave(out *bytes.Buffer, wave *Wave) {
+	var content bytes.Buffer
+
+	var format bytes.Buffer
+	writeLE(
+		&format,
+		wave.Format.Format,
+		wave.Format.NChannels,
+		wave.Format.SampleRate,
+		wave.Format.ByteRate,
+		wave.Format.BlockAlign,
+		wave.Format.BitsPerSample,
+	)
+	writeChunk(&content, FMT, format.Bytes())
+
+	writeWaveSample(&content, wave.WaveSample)
+	writeChunk(&content, DATA, wave.Data)
+	writeName(&content, wave.Name)
+
+	writeList(out, WAVE, content.Bytes())
+}
+
+// Serialize writes the dls file with a pool table where
+// the cue index of each wave matches its index in Waves
+func (file *Dls) Serialize(out io.Writer) error {
+	var content bytes.Buffer
+
+	var format uint32 = DLS_FORMAT
+	binary.Write(&content, binary.BigEndian, &format)
+
+	var collectionHeader bytes.Buffer
+	writeLE(&collectionHeader, uint32(len(file.Instruments)))
+	writeChunk(&content, COLH, collectionHeader.Bytes())
+
+	var instruments bytes.Buffer
+
+	for _, instrument := range file.Instruments {
+		writeInstrument(&instruments, instrument)
+	}
+
+	writeList(&content, LINS, instruments.Bytes())
+
+	var waves bytes.Buffer
+	var poolTable bytes.Buffer
+	writeLE(&poolTable, uint32(8), uint32(len(file.Waves)))
+
+	for _, wave := range file.Waves {
+		writeLE(&poolTable, uint32(waves.Len()))
+		writeWave(&waves, wave)
+	}
+
+	writeChunk(&content, PTBL, poolTable.Bytes())
+	writeList(&content, WVPL, waves.Bytes())
+	writeName(&content, file.Name)
+
+	var header uint32 = RIFF_HEADER
+	err := binary.Write(out, binary.BigEndian, &header)
+
+	if err != nil {
+		return err
+	}
+
+	var size = uint32(content.Len())
+	binary.Write(out, binary.LittleEndian, &size)
+
+	_, err = out.Write(content.Bytes())
+	return err
+}
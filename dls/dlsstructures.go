@@ -0,0 +1,146 @@
+package dls
+
+import "github.com/lambertjamesd/sfz2n64/wav"
+
+const RIFF_HEADER = 0x52494646
+const LIST_HEADER = 0x4C495354
+const DLS_FORMAT = 0x444C5320
+
+const COLH = 0x636F6C68
+const VERS = 0x76657273
+const LINS = 0x6C696E73
+const INS = 0x696E7320
+const INSH = 0x696E7368
+const LRGN = 0x6C72676E
+const RGN = 0x72676E20
+const RGN2 = 0x72676E32
+const RGNH = 0x72676E68
+const WSMP = 0x77736D70
+const WLNK = 0x776C6E6B
+const LART = 0x6C617274
+const LAR2 = 0x6C617232
+const ART1 = 0x61727431
+const ART2 = 0x61727432
+const PTBL = 0x7074626C
+const WVPL = 0x7776706C
+const WAVE = 0x77617665
+const FMT = 0x666D7420
+const DATA = 0x64617461
+const INFO = 0x494E464F
+const INAM = 0x494E414D
+
+// set in Instrument.Bank for drum instruments
+const F_INSTRUMENT_DRUMS = 0x80000000
+
+const (
+	CONN_SRC_NONE = 0x0000
+)
+
+const (
+	CONN_DST_NONE             = 0x0000
+	CONN_DST_ATTENUATION      = 0x0001
+	CONN_DST_PITCH            = 0x0003
+	CONN_DST_PAN              = 0x0004
+	CONN_DST_EG1_ATTACKTIME   = 0x0206
+	CONN_DST_EG1_DECAYTIME    = 0x0207
+	CONN_DST_EG1_RELEASETIME  = 0x0209
+	CONN_DST_EG1_SUSTAINLEVEL = 0x020A
+	CONN_DST_EG1_DELAYTIME    = 0x020B
+	CONN_DST_EG1_HOLDTIME     = 0x020C
+)
+
+const (
+	WLOOP_TYPE_FORWARD = 0
+	WLOOP_TYPE_RELEASE = 1
+)
+
+// scale used for time, pitch, and gain values in connections
+const CONN_SCALE = 65536
+
+// absolute time cents value used for a time of 0
+const CONN_TIME_ZERO = -0x80000000
+
+type Connection struct {
+	Source      uint16
+	Control     uint16
+	Destination uint16
+	Transform   uint16
+	Scale       int32
+}
+
+type Loop struct {
+	Type   uint32
+	Start  uint32
+	Length uint32
+}
+
+type WaveSample struct {
+	UnityNote uint16
+	FineTune  int16
+	// gain in 1/655360 dB
+	Attenuation int32
+	Options     uint32
+	Loops       []Loop
+}
+
+type WaveLink struct {
+	Options    uint16
+	PhaseGroup uint16
+	Channel    uint32
+	// index into Dls.Waves
+	TableIndex uint32
+}
+
+type Region struct {
+	KeyLow       uint16
+	KeyHigh      uint16
+	VelocityLow  uint16
+	VelocityHigh uint16
+	Options      uint16
+	KeyGroup     uint16
+	// nil if the region uses the WaveSample of the wave
+	WaveSample *WaveSample
+	WaveLink   WaveLink
+	// nil if the region uses the articulation of the instrument
+	Articulation []Connection
+}
+
+type Instrument struct {
+	Name         string
+	Bank         uint32
+	Program      uint32
+	Regions      []*Region
+	Articulation []Connection
+}
+
+type Wave struct {
+	Name       string
+	Format     wav.WaveHeader
+	WaveSample *WaveSample
+	Data       []byte
+}
+
+type Dls struct {
+	Name        string
+	Instruments []*Instrument
+	Waves       []*Wave
+}
+
+func (inst *Instrument) IsDrums() bool {
+	return inst.Bank&F_INSTRUMENT_DRUMS != 0
+}
+
+// BankNumber combines the bank select msb and lsb into a single number
+func (inst *Instrument) BankNumber() uint16 {
+	return uint16((inst.Bank>>8)&0x7F)<<7 | uint16(inst.Bank&0x7F)
+}
+
+func BankFromNumber(bankNumber uint16, isDrums bool) uint32 {
+	var result = uint32(bankNumber>>7)<<8 | uint32(bankNumber&0x7F)
+
+	if isDrums {
+		result = result | F_INSTRUMENT_DRUMS
+	}
+
+	return result
+}
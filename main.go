@@ -1,17 +1,30 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/lambertjamesd/sfz2n64/adpcm"
+	"github.com/lambertjamesd/sfz2n64/audioconvert"
 	"github.com/lambertjamesd/sfz2n64/convert"
+	"github.com/lambertjamesd/sfz2n64/midi"
 )
 
 type SFZConvertArgs struct {
-	TargetSampleRate    int
-	BankSequenceMapping string
+	TargetSampleRate       int
+	BankSequenceMapping    string
+	ResampleSettings       audioconvert.ResampleSettings
+	SampleRateStrategy     audioconvert.SampleRateStrategy
+	TblBudget              int
+	SharedCodebooks        int
+	SharedCodebookSettings *adpcm.CompressionSettings
+	RemapPrograms          string
+	CompactInstruments     bool
+	PCMConversionSettings  *audioconvert.PCMConversionSettings
 }
 
 func ParseBankConvertArgs(args map[string]interface{}) (*SFZConvertArgs, error) {
@@ -21,13 +34,100 @@ func ParseBankConvertArgs(args map[string]interface{}) (*SFZConvertArgs, error)
 	sampleRate, _ := intermediate.(int64)
 	result.TargetSampleRate = int(sampleRate)
 
+	intermediate, _ = args["--tbl-budget"]
+	tblBudget, _ := intermediate.(int64)
+	result.TblBudget = int(tblBudget)
+
+	intermediate, _ = args["--resample-quality"]
+	resampleQualityName, _ := intermediate.(string)
+	resampleQuality, err := audioconvert.ParseResampleQuality(resampleQualityName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.ResampleSettings.Quality = resampleQuality
+
+	intermediate, _ = args["--sample-rate-strategy"]
+	sampleRateStrategyName, _ := intermediate.(string)
+	sampleRateStrategy, err := audioconvert.ParseSampleRateStrategy(sampleRateStrategyName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.SampleRateStrategy = sampleRateStrategy
+
 	intermediate, _ = args["--bank_sequence_mapping"]
 	bankSequenceMapping, _ := intermediate.(string)
 	result.BankSequenceMapping = bankSequenceMapping
 
+	intermediate, _ = args["--remap-programs"]
+	remapPrograms, _ := intermediate.(string)
+	result.RemapPrograms = remapPrograms
+
+	intermediate, _ = args["--compact-instruments"]
+	compactInstruments, _ := intermediate.(bool)
+	result.CompactInstruments = compactInstruments
+
+	intermediate, _ = args["--shared-codebooks"]
+	sharedCodebooks, _ := intermediate.(int64)
+	result.SharedCodebooks = int(sharedCodebooks)
+
+	if sharedCodebooks != 0 {
+		compressionSettings, err := ParseCompressionSettings(args)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.SharedCodebookSettings = compressionSettings
+	}
+
+	pcmSettings, err := ParsePCMConversionSettings(args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.PCMConversionSettings = pcmSettings
+
+	intermediate, _ = args["--new-codebook"]
+	newCodebook, _ := intermediate.(bool)
+
+	if newCodebook {
+		compressionSettings, err := ParseCompressionSettings(args)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.ResampleSettings.CompressionSettings = compressionSettings
+	}
+
 	return &result, nil
 }
 
+func ParseLoopMarkerSettings(args map[string]interface{}) midi.LoopMarkerSettings {
+	var result = midi.DefaultLoopMarkerSettings()
+
+	intermediate, _ := args["--loop-start-marker"]
+	result.StartMarker, _ = intermediate.(string)
+
+	intermediate, _ = args["--loop-end-marker"]
+	result.EndMarker, _ = intermediate.(string)
+
+	intermediate, _ = args["--loop-start-cc"]
+	startController, _ := intermediate.(int64)
+	result.StartController = int(startController)
+
+	intermediate, _ = args["--loop-end-cc"]
+	endController, _ := intermediate.(int64)
+	result.EndController = int(endController)
+
+	return result
+}
+
 func ParseCompressionSettings(args map[string]interface{}) (*adpcm.CompressionSettings, error) {
 	var result adpcm.CompressionSettings = adpcm.DefaultCompressionSettings()
 
@@ -51,23 +151,103 @@ func ParseCompressionSettings(args map[string]interface{}) (*adpcm.CompressionSe
 	refineIters, _ := intermediate.(int64)
 	result.RefineIters = int(refineIters)
 
+	intermediate, _ = args["--adpcm-encoder"]
+	encoderName, _ := intermediate.(string)
+	encoder, err := adpcm.ParseEncoder(encoderName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.Encoder = encoder
+
+	intermediate, _ = args["--search-codebook"]
+	searchCodebook, _ := intermediate.(bool)
+
+	if searchCodebook {
+		var search = adpcm.DefaultCodebookSearchSettings()
+
+		intermediate, _ = args["--target-snr"]
+		targetSNR, _ := intermediate.(float64)
+		search.TargetSNR = targetSNR
+
+		result.Search = &search
+	}
+
+	return &result, nil
+}
+
+// prints the settings chosen by the codebook search to out
+func reportCodebookSearch(settings *adpcm.CompressionSettings, out io.Writer) {
+	if settings != nil && settings.Search != nil {
+		settings.Search.Report = out
+	}
+}
+
+func ParsePCMConversionSettings(args map[string]interface{}) (*audioconvert.PCMConversionSettings, error) {
+	var result audioconvert.PCMConversionSettings = audioconvert.DefaultPCMConversionSettings()
+
+	intermediate, _ := args["--channel"]
+	channel, _ := intermediate.(string)
+
+	if channel == "mix" || channel == "" {
+		result.Channel = audioconvert.PCM_CHANNEL_MIX
+	} else if channel == "left" {
+		result.Channel = 0
+	} else if channel == "right" {
+		result.Channel = 1
+	} else {
+		index, err := strconv.ParseInt(channel, 10, 32)
+
+		if err != nil || index < 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid value for --channel '%s'. Expected mix, left, right, or a channel index", channel))
+		}
+
+		result.Channel = int(index)
+	}
+
+	intermediate, _ = args["--dither"]
+	dither, _ := intermediate.(bool)
+	result.Dither = dither
+
 	return &result, nil
 }
 
 func main() {
-	var args Args = NewArgs("sfz2n64 [options] -o output.sfz|output.ins|output.ctl input.sfz|input.ins|input.ctl")
+	var args Args = NewArgs("sfz2n64 [options] -o output.sfz|output.ins|output.ctl|output.sf2|output.dls input.sfz|input.sf2|input.dls|input.ins|input.ctl")
 
 	args.AddFlagArg([]string{"-h", "--help"}, "print this help message")
 	args.AddStringArg([]string{"-o", "--output"}, "the output file", "")
 	args.AddIntegerArg([]string{"--sample-rate"}, "changes the sample rate of instrument banks", 0, 0, 200000)
+	args.AddStringArg([]string{"--sample-rate-strategy"}, "how sounds with a different sample rate than their bank are fixed, resample, detune, or ignore", "resample")
+	args.AddStringArg([]string{"--resample-quality"}, "the filter used by --sample-rate, linear, low, medium, or high", "linear")
+	args.AddIntegerArg([]string{"--tbl-budget"}, "lowers the sample rate of individual sounds until the tbl file is at most this many bytes", 0, 0, 0x7fffffff)
 	args.AddStringArg([]string{"--bank_sequence_mapping"}, "A list of midi files used to filter out unused sounds and instruments", "")
+	args.AddFlagArg([]string{"--compact-instruments"}, "renumber instruments to remove empty program numbers, updating the songs in --bank_sequence_mapping")
+	args.AddStringArg([]string{"--remap-programs"}, "auto to replace programs that aren't in the bank with a similar instrument or a file mapping programs to instruments in the bank", "")
 
 	args.AddIntegerArg([]string{"--order"}, "the order used in adpcm compression", 2, 1, 16)
 	args.AddIntegerArg([]string{"--frame-size"}, "the number of samples to include in a single adpcm frame", 16, 16, 16)
 	args.AddFloatArg([]string{"--threshold"}, "the threshold used in adpcm compression", 10, 1, 32)
 	args.AddIntegerArg([]string{"--bits"}, "the number of bits to use for adpcm compression", 2, 1, 4)
 	args.AddIntegerArg([]string{"--refine-iterations"}, "the number of refinement iterations to use in adpcm compression", 2, 1, 20000)
+	args.AddFlagArg([]string{"--search-codebook"}, "pick the smallest codebook for each sound that reaches --target-snr instead of using --order and --bits")
+	args.AddFloatArg([]string{"--target-snr"}, "the signal to noise ratio in decibels used by --search-codebook", 30, 0, 144)
+	args.AddStringArg([]string{"--adpcm-encoder"}, "greedy to encode adpcm frames like the sdk or search for a slower higher quality encoding", "greedy")
 	args.AddFlagArg([]string{"--compress"}, "compress any uncompressed audio when converting")
+	args.AddIntegerArg([]string{"--shared-codebooks"}, "groups similar compressed sounds in a bank to share this many codebooks", 0, 0, 4096)
+	args.AddFlagArg([]string{"--new-codebook"}, "calculate a new codebook when resampling compressed audio instead of reusing the existing one")
+	args.AddFlagArg([]string{"--compact"}, "store sequences in a sequence bank using the compact format")
+	args.AddStringArg([]string{"--loop-start-marker"}, "the text of midi markers that start a loop", "loopStart")
+	args.AddStringArg([]string{"--loop-end-marker"}, "the text of midi markers that end a loop, optionally followed by the loop count", "loopEnd")
+	args.AddIntegerArg([]string{"--loop-start-cc"}, "a controller that starts a loop, -1 for none", -1, -1, 127)
+	args.AddIntegerArg([]string{"--loop-end-cc"}, "a controller that ends a loop with the value as the loop count, -1 for none", -1, -1, 127)
+	args.AddStringArg([]string{"--channel"}, "the channel used from multichannel audio files, mix, left, right, or a channel index", "mix")
+	args.AddFlagArg([]string{"--dither"}, "add dither when reducing audio files to 16 bits")
+	args.AddIntegerArg([]string{"--max-voices"}, "truncates or removes notes from a midi file so no more than this many sounds play at once", 0, 0, 256)
+	args.AddStringArg([]string{"--report-format"}, "the format of the report printed when checking songs against a bank, text or json", "text")
+	args.AddFlagArg([]string{"--validate"}, "check that every note in the input songs can be played by the output bank without changing anything")
+	args.AddIntegerArg([]string{"--output-rate"}, "the output sample rate of the synthesizer used by --validate to check pitch limits, 0 to use the sample rate of the bank", 0, 0, 200000)
 
 	namedArgs, orderedArgs, errors := args.Parse(os.Args[1:len(os.Args)])
 
@@ -86,6 +266,24 @@ func main() {
 		return
 	}
 
+	pcmSettings, err := ParsePCMConversionSettings(namedArgs)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	intermediate, _ = namedArgs["--report-format"]
+	reportFormat, _ := intermediate.(string)
+
+	if reportFormat != "text" && reportFormat != "json" {
+		fmt.Println(fmt.Sprintf("Invalid report format '%s'. Expected text or json", reportFormat))
+		os.Exit(1)
+	}
+
+	intermediate, _ = namedArgs["--validate"]
+	validate, _ := intermediate.(bool)
+
 	var input = orderedArgs[0]
 
 	var ext = filepath.Ext(input)
@@ -93,8 +291,17 @@ func main() {
 
 	if isRomFile(ext) && isBankFile(outExt) {
 		extractFromRom(input, output)
-	} else if isRomFile(ext) && outExt == ".mid" || outExt == ".midi" {
+	} else if isRomFile(ext) && (outExt == ".mid" || outExt == ".midi") {
 		extractMidiFromRom(input, output)
+	} else if isSequenceFile(ext) && isSequenceFile(outExt) {
+		convertSequence(input, output, ParseLoopMarkerSettings(namedArgs))
+	} else if isSequenceFile(ext) && outExt == ".sbk" {
+		intermediate, _ = namedArgs["--compact"]
+		compact, _ := intermediate.(bool)
+
+		buildSequenceBank(output, orderedArgs, compact, ParseLoopMarkerSettings(namedArgs))
+	} else if ext == ".sbk" && isSequenceFile(outExt) {
+		extractSequenceBank(input, output)
 	} else if isBankFile(ext) && isBankFile(outExt) {
 		args, err := ParseBankConvertArgs(namedArgs)
 
@@ -118,9 +325,11 @@ func main() {
 				fmt.Println(err)
 				os.Exit(1)
 			}
+
+			reportCodebookSearch(compressionSettings, os.Stdout)
 		}
 
-		err := convert.WriteSoundBank(output, orderedArgs, compressionSettings)
+		err := convert.WriteSoundBank(output, orderedArgs, compressionSettings, pcmSettings)
 
 		if err != nil {
 			fmt.Println(err)
@@ -136,11 +345,24 @@ func main() {
 			os.Exit(1)
 		}
 
-		convertAudio(input, output, compressionSettings)
+		reportCodebookSearch(compressionSettings, os.Stdout)
+
+		convertAudio(input, output, compressionSettings, pcmSettings)
+	} else if validate && isSequenceFile(ext) && isBankFile(outExt) {
+		intermediate, _ = namedArgs["--output-rate"]
+		outputRate, _ := intermediate.(int64)
+
+		validateSongs(orderedArgs, output, int(outputRate), reportFormat, pcmSettings)
 	} else if ext == ".mid" && isBankFile(outExt) {
-		extractMidi(input, output)
+		intermediate, _ = namedArgs["--max-voices"]
+		maxVoices, _ := intermediate.(int64)
+
+		intermediate, _ = namedArgs["--remap-programs"]
+		remapPrograms, _ := intermediate.(string)
+
+		extractMidi(input, output, int(maxVoices), reportFormat, remapPrograms, pcmSettings)
 	} else {
-		fmt.Println(fmt.Sprintf("Invalid input file '%s'. Expected .sfz or .ctl file\n", input))
+		fmt.Println(fmt.Sprintf("Invalid input file '%s'. Expected .sfz, .sf2, .dls or .ctl file\n", input))
 		os.Exit(1)
 	}
 }
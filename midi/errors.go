@@ -0,0 +1,39 @@
+package midi
+
+import "fmt"
+
+// MidiError wraps an error with where in the midi file it happened
+type MidiError struct {
+	Track int
+	// the index of the event in the track, -1 if it isn't known
+	Event int
+	// the byte offset from the start of the track data, -1 if it isn't known
+	Offset int64
+	Err    error
+}
+
+func (err *MidiError) Error() string {
+	var location = fmt.Sprintf("track %d", err.Track)
+
+	if err.Event != -1 {
+		location = location + fmt.Sprintf(" event %d", err.Event)
+	}
+
+	if err.Offset != -1 {
+		location = location + fmt.Sprintf(" offset %d", err.Offset)
+	}
+
+	return fmt.Sprintf("%s: %s", location, err.Err.Error())
+}
+
+func (err *MidiError) Unwrap() error {
+	return err.Err
+}
+
+type UnknownEventError struct {
+	EventType MidiEventType
+}
+
+func (err *UnknownEventError) Error() string {
+	return fmt.Sprintf("Unknown event type %d", err.EventType)
+}
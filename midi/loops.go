@@ -0,0 +1,272 @@
+package midi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the controllers used by the N64 sequence player for loops
+const (
+	LoopStartController    = 102
+	LoopEndController      = 103
+	LoopCountController    = 104
+	LoopCountBigController = 105
+)
+
+// the loop count used for loops that repeat forever
+const LoopInfinite = 0xFF
+
+// the loop number is stored in a 7 bit controller value
+const maxLoopNumber = 127
+
+type LoopMarkerSettings struct {
+	// text of the marker or cue events that start and end a loop.
+	// The end marker can be followed by the number of times to loop
+	StartMarker string
+	EndMarker   string
+	// controllers that start and end a loop, -1 to ignore controllers.
+	// The value of the end controller is the number of times to loop
+	// with 0 looping forever
+	StartController int
+	EndController   int
+}
+
+func DefaultLoopMarkerSettings() LoopMarkerSettings {
+	return LoopMarkerSettings{
+		StartMarker:     "loopStart",
+		EndMarker:       "loopEnd",
+		StartController: -1,
+		EndController:   -1,
+	}
+}
+
+type loopMarker struct {
+	track int
+	index int
+	event *MidiEvent
+	start bool
+	count int
+}
+
+func isLoopMarkerText(event *MidiEvent) bool {
+	return event.IsMeta() && (event.FirstParam == MetaMarker || event.FirstParam == MetaCue)
+}
+
+func markerLoopCount(text []string) (int, error) {
+	if len(text) < 2 {
+		return LoopInfinite, nil
+	}
+
+	count, err := strconv.Atoi(text[1])
+
+	if err != nil || count < 1 || count >= LoopInfinite {
+		return 0, errors.New(fmt.Sprintf("Invalid loop count '%s', expected a number from 1 to %d", text[1], LoopInfinite-1))
+	}
+
+	return count, nil
+}
+
+func (settings *LoopMarkerSettings) parseMarker(event *MidiEvent) (bool, bool, int, error) {
+	if isLoopMarkerText(event) {
+		var text = strings.Fields(string(event.Metadata))
+
+		if len(text) == 0 {
+			return false, false, 0, nil
+		}
+
+		if len(settings.StartMarker) > 0 && strings.EqualFold(text[0], settings.StartMarker) {
+			return true, true, 0, nil
+		} else if len(settings.EndMarker) > 0 && strings.EqualFold(text[0], settings.EndMarker) {
+			count, err := markerLoopCount(text)
+			return true, false, count, err
+		}
+	} else if event.EventType == ControlChange {
+		if int(event.FirstParam) == settings.StartController {
+			return true, true, 0, nil
+		} else if int(event.FirstParam) == settings.EndController {
+			if event.SecondParam == 0 {
+				return true, false, LoopInfinite, nil
+			}
+
+			return true, false, int(event.SecondParam), nil
+		}
+	}
+
+	return false, false, 0, nil
+}
+
+func loopEvents(tick uint32, channels []uint8, start bool, loopNumber int, count int) []*MidiEvent {
+	var result []*MidiEvent = nil
+
+	for _, channel := range channels {
+		if !start && count < 128 {
+			result = append(result, &MidiEvent{tick, ControlChange, channel, LoopCountController, uint8(count), nil})
+		} else if !start && count != LoopInfinite {
+			result = append(result, &MidiEvent{tick, ControlChange, channel, LoopCountBigController, uint8(count - 128), nil})
+		}
+
+		var controller uint8 = LoopEndController
+
+		if start {
+			controller = LoopStartController
+		}
+
+		result = append(result, &MidiEvent{tick, ControlChange, channel, controller, uint8(loopNumber), nil})
+	}
+
+	return result
+}
+
+// ConvertLoopMarkers replaces loop markers with the loop controllers used by the N64
+// sequence player. Markers apply to the whole song so the loop controllers are added
+// to every channel. Returns the converted midi file and the number of loops found
+func ConvertLoopMarkers(midiFile *Midi, settings LoopMarkerSettings) (*Midi, int, error) {
+	var markers []*loopMarker = nil
+	var usedChannels [16]bool
+	var nextLoop = 0
+
+	for trackIndex, track := range midiFile.Tracks {
+		for eventIndex, event := range track.Events {
+			isMarker, start, count, err := settings.parseMarker(event)
+
+			if err != nil {
+				return nil, 0, &MidiError{trackIndex, eventIndex, -1, err}
+			}
+
+			if isMarker {
+				markers = append(markers, &loopMarker{trackIndex, eventIndex, event, start, count})
+				continue
+			}
+
+			if event.EventType == Metadata {
+				continue
+			}
+
+			usedChannels[event.Channel] = true
+
+			// new loops are numbered after any loops that are already in the file
+			if event.EventType == ControlChange && (event.FirstParam == LoopStartController || event.FirstParam == LoopEndController) {
+				if int(event.SecondParam) >= nextLoop {
+					nextLoop = int(event.SecondParam) + 1
+				}
+			}
+		}
+	}
+
+	if len(markers) == 0 {
+		return midiFile, 0, nil
+	}
+
+	var channels []uint8 = nil
+
+	for channel, used := range usedChannels {
+		if used {
+			channels = append(channels, uint8(channel))
+		}
+	}
+
+	// a loop that ends on the same tick another starts has to end first
+	sort.SliceStable(markers, func(i, j int) bool {
+		if markers[i].event.AbsoluteTime == markers[j].event.AbsoluteTime {
+			return !markers[i].start && markers[j].start
+		}
+
+		return markers[i].event.AbsoluteTime < markers[j].event.AbsoluteTime
+	})
+
+	var replacements = make(map[*MidiEvent][]*MidiEvent)
+	var openLoops []*loopMarker = nil
+	var loopNumbers = make(map[*loopMarker]int)
+
+	for _, marker := range markers {
+		if marker.start {
+			if nextLoop > maxLoopNumber {
+				return nil, 0, &MidiError{marker.track, marker.index, -1, errors.New(fmt.Sprintf("Too many loops, at most %d are supported", maxLoopNumber+1))}
+			}
+
+			loopNumbers[marker] = nextLoop
+			replacements[marker.event] = loopEvents(marker.event.AbsoluteTime, channels, true, nextLoop, 0)
+			openLoops = append(openLoops, marker)
+			nextLoop = nextLoop + 1
+		} else {
+			if len(openLoops) == 0 {
+				return nil, 0, &MidiError{marker.track, marker.index, -1, errors.New(fmt.Sprintf("Loop end at tick %d has no loop start", marker.event.AbsoluteTime))}
+			}
+
+			var loopStart = openLoops[len(openLoops)-1]
+			openLoops = openLoops[0 : len(openLoops)-1]
+			replacements[marker.event] = loopEvents(marker.event.AbsoluteTime, channels, false, loopNumbers[loopStart], marker.count)
+		}
+	}
+
+	if len(openLoops) > 0 {
+		var marker = openLoops[0]
+		return nil, 0, &MidiError{marker.track, marker.index, -1, errors.New(fmt.Sprintf("Loop start at tick %d has no loop end", marker.event.AbsoluteTime))}
+	}
+
+	var result = &Midi{
+		Type:            midiFile.Type,
+		TicksPerQuarter: midiFile.TicksPerQuarter,
+		Tracks:          nil,
+		UnknownChunks:   midiFile.UnknownChunks,
+	}
+
+	for _, track := range midiFile.Tracks {
+		var newTrack Track
+
+		for _, event := range track.Events {
+			replacement, ok := replacements[event]
+
+			if ok {
+				newTrack.Events = append(newTrack.Events, replacement...)
+			} else {
+				newTrack.Events = append(newTrack.Events, event)
+			}
+		}
+
+		result.Tracks = append(result.Tracks, &newTrack)
+	}
+
+	err := ValidateLoops(result)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return result, len(markers) / 2, nil
+}
+
+// ValidateLoops checks that every loop start controller in each channel
+// has a matching loop end and that loops in a channel don't overlap
+func ValidateLoops(midiFile *Midi) error {
+	var openLoops [16][]*MidiEvent
+
+	for _, event := range MergeTracks(midiFile).Tracks[0].Events {
+		if event.EventType != ControlChange {
+			continue
+		}
+
+		var channelLoops = openLoops[event.Channel]
+
+		if event.FirstParam == LoopStartController {
+			openLoops[event.Channel] = append(channelLoops, event)
+		} else if event.FirstParam == LoopEndController {
+			if len(channelLoops) == 0 || channelLoops[len(channelLoops)-1].SecondParam != event.SecondParam {
+				return errors.New(fmt.Sprintf("Loop end for loop %d in channel %d at tick %d does not match a loop start", event.SecondParam, event.Channel, event.AbsoluteTime))
+			}
+
+			openLoops[event.Channel] = channelLoops[0 : len(channelLoops)-1]
+		}
+	}
+
+	for channel, channelLoops := range openLoops {
+		if len(channelLoops) > 0 {
+			return errors.New(fmt.Sprintf("Loop start for loop %d in channel %d at tick %d has no loop end", channelLoops[0].SecondParam, channel, channelLoops[0].AbsoluteTime))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package midi
+
+import "sort"
+
+// IsNoteOff is true for note off events and note on events with a velocity of 0
+func IsNoteOff(event *MidiEvent) bool {
+	return event.EventType == MidiOff || (event.EventType == MidiOn && event.SecondParam == 0)
+}
+
+// the order of events at the same tick
+const (
+	mergeOrderMeta = iota
+	mergeOrderNoteOff
+	mergeOrderChannel
+	mergeOrderNoteOn
+)
+
+type mergeEvent struct {
+	event *MidiEvent
+	order int
+}
+
+type noteId struct {
+	channel uint8
+	key     uint8
+}
+
+func mergeOrderForTrack(track *Track) []mergeEvent {
+	var result []mergeEvent = nil
+	// notes started at the current tick
+	var started = make(map[noteId]bool)
+	var currentTick uint32 = 0
+
+	for _, event := range track.Events {
+		if event.EventType == Metadata && event.FirstParam == MetaEnd {
+			continue
+		}
+
+		if event.AbsoluteTime != currentTick {
+			currentTick = event.AbsoluteTime
+			started = make(map[noteId]bool)
+		}
+
+		var order = mergeOrderChannel
+		var note = noteId{event.Channel, event.FirstParam}
+
+		if event.EventType == Metadata {
+			order = mergeOrderMeta
+		} else if IsNoteOff(event) {
+			// a note that starts and stops on the same tick has to stay in order
+			if started[note] {
+				order = mergeOrderNoteOn
+			} else {
+				order = mergeOrderNoteOff
+			}
+		} else if event.EventType == MidiOn {
+			order = mergeOrderNoteOn
+			started[note] = true
+		}
+
+		result = append(result, mergeEvent{event, order})
+	}
+
+	return result
+}
+
+// MergeTracks combines every track into a single track ordered by time
+// and returns it as a type 0 midi file. Events at the same tick are
+// ordered with meta events first followed by note offs, other channel
+// events, then note ons. Otherwise events keep the order of their track
+// and tracks keep the order they are in the file. The end of track
+// events are replaced by one at the time of the last event
+func MergeTracks(midiFile *Midi) *Midi {
+	var events []mergeEvent = nil
+	var endTime uint32 = 0
+
+	for _, track := range midiFile.Tracks {
+		for _, event := range track.Events {
+			if event.AbsoluteTime > endTime {
+				endTime = event.AbsoluteTime
+			}
+		}
+
+		events = append(events, mergeOrderForTrack(track)...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].event.AbsoluteTime != events[j].event.AbsoluteTime {
+			return events[i].event.AbsoluteTime < events[j].event.AbsoluteTime
+		}
+
+		return events[i].order < events[j].order
+	})
+
+	var result = &Track{Events: make([]*MidiEvent, 0, len(events)+1)}
+
+	for _, event := range events {
+		result.Events = append(result.Events, event.event)
+	}
+
+	result.Events = append(result.Events, &MidiEvent{
+		AbsoluteTime: endTime,
+		EventType:    Metadata,
+		Channel:      0xF,
+		FirstParam:   MetaEnd,
+		SecondParam:  0,
+		Metadata:     nil,
+	})
+
+	return &Midi{
+		Type:            SingleTrack,
+		TicksPerQuarter: midiFile.TicksPerQuarter,
+		Tracks:          []*Track{result},
+		// chunks after later tracks are written after the merged track
+		UnknownChunks: midiFile.UnknownChunks,
+	}
+}
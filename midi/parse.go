@@ -1,10 +1,12 @@
 package midi
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 )
 
 func readVarInt(io io.Reader) (uint32, uint32, error) {
@@ -29,7 +31,31 @@ func readVarInt(io io.Reader) (uint32, uint32, error) {
 	return result, bytesRead, nil
 }
 
-func readMidiEvent(reader io.Reader, prevEvent *MidiEvent) (*MidiEvent, uint32, error) {
+func readEventData(reader io.Reader) ([]byte, uint32, error) {
+	dataLength, bytesRead, err := readVarInt(reader)
+
+	if err != nil {
+		return nil, bytesRead, err
+	}
+
+	if dataLength == 0 {
+		return nil, bytesRead, nil
+	}
+
+	var data []byte = make([]byte, dataLength)
+	_, err = io.ReadFull(reader, data)
+	bytesRead = bytesRead + dataLength
+
+	if err != nil {
+		return nil, bytesRead, err
+	}
+
+	return data, bytesRead, nil
+}
+
+// runningStatus is the status of the last channel event. It is kept
+// after meta and system exclusive events since many files depend on it
+func readMidiEvent(reader io.Reader, prevEvent *MidiEvent, runningStatus uint8) (*MidiEvent, uint32, error) {
 	eventTime, bytesRead, err := readVarInt(reader)
 
 	if prevEvent != nil {
@@ -54,132 +80,161 @@ func readMidiEvent(reader io.Reader, prevEvent *MidiEvent) (*MidiEvent, uint32,
 	var firstByte uint8
 
 	if eventChannel < 128 {
-		if prevEvent == nil {
+		if runningStatus == 0 {
 			return nil, 0, errors.New("Running event with no previous midi event")
-		} else {
-			channel = prevEvent.Channel
-			eventType = prevEvent.EventType
 		}
 
+		channel = runningStatus & 0xF
+		eventType = MidiEventType(runningStatus >> 4)
 		firstByte = eventChannel
-	} else {
-		channel = eventChannel & 0xF
-		eventType = MidiEventType(eventChannel >> 4)
+	} else if eventChannel == 0xF0 || eventChannel == 0xF7 {
+		data, dataBytes, err := readEventData(reader)
+		bytesRead = bytesRead + dataBytes
+
+		if err != nil {
+			return nil, bytesRead, err
+		}
 
+		return &MidiEvent{
+			eventTime,
+			Metadata,
+			eventChannel & 0xF,
+			0,
+			0,
+			data,
+		}, bytesRead, nil
+	} else if eventChannel == 0xFF {
 		err = binary.Read(reader, binary.BigEndian, &firstByte)
 		bytesRead = bytesRead + 1
 
 		if err != nil {
 			return nil, bytesRead, err
 		}
-	}
 
-	if eventType == Metadata {
-		extraBytes, extraByteLen, err := readVarInt(reader)
-		bytesRead = bytesRead + extraByteLen
+		data, dataBytes, err := readEventData(reader)
+		bytesRead = bytesRead + dataBytes
 
 		if err != nil {
 			return nil, bytesRead, err
 		}
 
-		if extraBytes == 0 {
-			return &MidiEvent{
-				eventTime,
-				eventType,
-				channel,
-				firstByte,
-				0,
-				nil,
-			}, bytesRead, nil
-		} else {
-			var data []byte = make([]byte, extraBytes)
-			dataLength, err := reader.Read(data)
-			bytesRead = bytesRead + uint32(extraBytes)
+		return &MidiEvent{
+			eventTime,
+			Metadata,
+			MetaChannel,
+			firstByte,
+			0,
+			data,
+		}, bytesRead, nil
+	} else {
+		channel = eventChannel & 0xF
+		eventType = MidiEventType(eventChannel >> 4)
 
-			if err != nil {
-				return nil, bytesRead, err
-			}
+		if eventType == Metadata {
+			return nil, bytesRead, &UnknownEventError{MidiEventType(eventChannel)}
+		}
 
-			if dataLength != int(extraBytes) {
-				return nil, bytesRead, errors.New("Could not read data")
-			}
+		err = binary.Read(reader, binary.BigEndian, &firstByte)
+		bytesRead = bytesRead + 1
 
-			return &MidiEvent{
-				eventTime,
-				eventType,
-				channel,
-				firstByte,
-				0,
-				data,
-			}, bytesRead, nil
+		if err != nil {
+			return nil, bytesRead, err
 		}
-	} else {
-		var secondByte uint8
+	}
 
-		if firstByte&0x80 != 0 {
-			return nil, bytesRead, errors.New("Data had high bit set")
-		}
+	var secondByte uint8
 
-		if bytesForEvent(eventType) == 2 {
-			err = binary.Read(reader, binary.BigEndian, &secondByte)
-			bytesRead = bytesRead + 1
+	if firstByte&0x80 != 0 {
+		return nil, bytesRead, errors.New("Data had high bit set")
+	}
 
-			if err != nil {
-				return nil, bytesRead, err
-			}
+	byteCount, err := bytesForEvent(eventType)
 
-			if secondByte&0x80 != 0 {
-				return nil, bytesRead, errors.New("Data had high bit set")
-			}
-		} else {
-			secondByte = 0
+	if err != nil {
+		return nil, bytesRead, err
+	}
+
+	if byteCount == 2 {
+		err = binary.Read(reader, binary.BigEndian, &secondByte)
+		bytesRead = bytesRead + 1
+
+		if err != nil {
+			return nil, bytesRead, err
 		}
 
-		return &MidiEvent{
-			eventTime,
-			eventType,
-			channel,
-			firstByte,
-			secondByte,
-			nil,
-		}, bytesRead, nil
+		if secondByte&0x80 != 0 {
+			return nil, bytesRead, errors.New("Data had high bit set")
+		}
+	} else {
+		secondByte = 0
 	}
+
+	return &MidiEvent{
+		eventTime,
+		eventType,
+		channel,
+		firstByte,
+		secondByte,
+		nil,
+	}, bytesRead, nil
 }
 
-func readTrack(reader io.Reader) (*Track, error) {
-	var trackHeader uint32
-	err := binary.Read(reader, binary.BigEndian, &trackHeader)
+func readChunk(reader io.Reader, tracksOnly bool) (uint32, []byte, error) {
+	var chunkType uint32
+	err := binary.Read(reader, binary.BigEndian, &chunkType)
 
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 
-	if trackHeader != TrackHeader {
-		return nil, errors.New("Invalid track header")
+	if tracksOnly && chunkType != TrackHeader {
+		return 0, nil, errors.New("Invalid track header")
 	}
 
-	var trackLength uint32
+	var chunkLength uint32
 
-	err = binary.Read(reader, binary.BigEndian, &trackLength)
+	err = binary.Read(reader, binary.BigEndian, &chunkLength)
 
 	if err != nil {
-		return nil, err
+		return 0, nil, err
+	}
+
+	// the buffer grows as data is read so a bad
+	// length can't allocate more than the file size
+	var data bytes.Buffer
+	_, err = io.CopyN(&data, reader, int64(chunkLength))
+
+	if err == io.EOF {
+		return 0, nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, nil, err
 	}
 
+	return chunkType, data.Bytes(), nil
+}
+
+func readTrack(data []byte, trackIndex int) (*Track, error) {
+	var reader = bytes.NewReader(data)
+	var trackLength = uint32(len(data))
 	var bytesRead uint32 = 0
 	var events []*MidiEvent = nil
 	var prevEvent *MidiEvent = nil
+	var runningStatus uint8 = 0
 
 	for bytesRead < trackLength {
-		event, byteLength, err := readMidiEvent(reader, prevEvent)
+		event, byteLength, err := readMidiEvent(reader, prevEvent, runningStatus)
 
 		if err != nil {
-			return nil, err
+			return nil, &MidiError{trackIndex, len(events), int64(bytesRead), err}
 		}
 
 		if event != nil {
 			events = append(events, event)
 			prevEvent = event
+
+			if event.EventType != Metadata {
+				runningStatus = uint8(event.EventType)<<4 | event.Channel
+			}
 		}
 		bytesRead = bytesRead + byteLength
 	}
@@ -189,7 +244,19 @@ func readTrack(reader io.Reader) (*Track, error) {
 	}, nil
 }
 
+// ReadMidi reads a midi file. Any chunks that aren't tracks are kept
+// in UnknownChunks including any after the last track
 func ReadMidi(reader io.Reader) (*Midi, error) {
+	return readMidi(reader, true)
+}
+
+// ReadEmbeddedMidi reads a midi file that is part of larger data
+// stopping at the end of the last track. Only track chunks are allowed
+func ReadEmbeddedMidi(reader io.Reader) (*Midi, error) {
+	return readMidi(reader, false)
+}
+
+func readMidi(reader io.Reader, trailingChunks bool) (*Midi, error) {
 	var midiHeader uint32
 	err := binary.Read(reader, binary.BigEndian, &midiHeader)
 
@@ -204,7 +271,11 @@ func ReadMidi(reader io.Reader) (*Midi, error) {
 	var headerLength uint32
 	err = binary.Read(reader, binary.BigEndian, &headerLength)
 
-	if headerLength != 6 {
+	if err != nil {
+		return nil, err
+	}
+
+	if headerLength < 6 {
 		return nil, errors.New(fmt.Sprintf("Invalid midi header length %d", headerLength))
 	}
 
@@ -234,24 +305,49 @@ func ReadMidi(reader io.Reader) (*Midi, error) {
 		return nil, err
 	}
 
-	var tracks []*Track = nil
+	// later versions of the format may add to the header
+	_, err = io.CopyN(ioutil.Discard, reader, int64(headerLength-6))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result = &Midi{
+		Type:            MidiFileType(midiType),
+		TicksPerQuarter: deltaTicksPerQuarter,
+		Tracks:          nil,
+		UnknownChunks:   nil,
+	}
 
-	var trackIndex uint16 = 0
+	for trailingChunks || len(result.Tracks) < int(trackCount) {
+		chunkType, data, err := readChunk(reader, !trailingChunks)
 
-	for trackIndex < trackCount {
-		track, err := readTrack(reader)
+		// chunks after the last track are optional and some
+		// files have a few bytes of padding at the end
+		if (err == io.EOF || err == io.ErrUnexpectedEOF) && len(result.Tracks) >= int(trackCount) {
+			break
+		}
 
 		if err != nil {
-			return nil, err
+			return nil, &MidiError{len(result.Tracks), -1, -1, err}
 		}
 
-		tracks = append(tracks, track)
-		trackIndex = trackIndex + 1
+		if chunkType == TrackHeader {
+			track, err := readTrack(data, len(result.Tracks))
+
+			if err != nil {
+				return nil, err
+			}
+
+			result.Tracks = append(result.Tracks, track)
+		} else {
+			result.UnknownChunks = append(result.UnknownChunks, &Chunk{
+				Type:       chunkType,
+				Data:       data,
+				TrackIndex: len(result.Tracks),
+			})
+		}
 	}
 
-	return &Midi{
-		MidiFileType(midiType),
-		deltaTicksPerQuarter,
-		tracks,
-	}, nil
+	return result, nil
 }
@@ -1,10 +1,5 @@
 package midi
 
-import (
-	"fmt"
-	"os"
-)
-
 const MidiHeader = 0x4D546864
 const TrackHeader = 0x4D54726B
 
@@ -39,6 +34,18 @@ const (
 	MetaSeqInfo        = 0x7F
 )
 
+// Metadata events use the channel to tell meta events
+// apart from system exclusive events
+const (
+	// F0 system exclusive, Metadata holds the bytes after the length
+	// including the final F7
+	SysExChannel = 0x0
+	// F7 escape, Metadata holds the bytes sent as is
+	EscapeChannel = 0x7
+	// FF meta event, FirstParam is the MetadataEventType
+	MetaChannel = 0xF
+)
+
 type MidiEvent struct {
 	AbsoluteTime uint32
 	EventType    MidiEventType
@@ -48,6 +55,15 @@ type MidiEvent struct {
 	Metadata     []byte
 }
 
+func (event *MidiEvent) IsMeta() bool {
+	return event.EventType == Metadata && event.Channel == MetaChannel
+}
+
+// IsSysEx is true for both system exclusive and escape events
+func (event *MidiEvent) IsSysEx() bool {
+	return event.EventType == Metadata && (event.Channel == SysExChannel || event.Channel == EscapeChannel)
+}
+
 type Track struct {
 	Events []*MidiEvent
 }
@@ -60,32 +76,55 @@ const (
 	MultipleTracksAsync = 0x2
 )
 
+// a chunk that isn't a track, kept so it can be written back out
+type Chunk struct {
+	Type uint32
+	Data []byte
+	// the number of tracks that came before the chunk
+	TrackIndex int
+}
+
 type Midi struct {
-	Type            MidiFileType
+	Type MidiFileType
+	// the time division, see IsSMPTE
 	TicksPerQuarter uint16
 	Tracks          []*Track
+	UnknownChunks   []*Chunk
 }
 
-func bytesForEvent(eventType MidiEventType) int {
+// IsSMPTE is true if the time division is in frames per second
+// instead of ticks per quarter note
+func (midi *Midi) IsSMPTE() bool {
+	return midi.TicksPerQuarter&0x8000 != 0
+}
+
+// SMPTEFormat returns the frames per second and the ticks per frame of a
+// SMPTE time division. 29 frames per second is used for 30 drop frame
+func (midi *Midi) SMPTEFormat() (int, int) {
+	return -int(int8(midi.TicksPerQuarter >> 8)), int(midi.TicksPerQuarter & 0xFF)
+}
+
+func SMPTEDivision(framesPerSecond int, ticksPerFrame int) uint16 {
+	return uint16(uint8(int8(-framesPerSecond)))<<8 | uint16(uint8(ticksPerFrame))
+}
+
+func bytesForEvent(eventType MidiEventType) (int, error) {
 	switch eventType {
 	case MidiOff:
-		return 2
+		return 2, nil
 	case MidiOn:
-		return 2
+		return 2, nil
 	case AfterTouch:
-		return 2
+		return 2, nil
 	case ControlChange:
-		return 2
+		return 2, nil
 	case ProgramChange:
-		return 1
+		return 1, nil
 	case ChannelAfterTouch:
-		return 1
+		return 1, nil
 	case PitchWheel:
-		return 2
+		return 2, nil
 	}
 
-	fmt.Println(fmt.Sprintf("Unknown event type %d", eventType))
-	os.Exit(1)
-
-	return 0
+	return 0, &UnknownEventError{eventType}
 }
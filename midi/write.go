@@ -20,7 +20,9 @@ func writeVarInt(writer io.Writer, value uint32, hasMore bool) error {
 	return binary.Write(writer, binary.BigEndian, &curr)
 }
 
-func writeEvent(writer io.Writer, event *MidiEvent, prevEvent *MidiEvent) error {
+// runningStatus is the status of the last channel event written or 0 if
+// there isn't one. Meta and system exclusive events clear it
+func writeEvent(writer io.Writer, event *MidiEvent, prevEvent *MidiEvent, runningStatus uint8) (uint8, error) {
 	var delta uint32 = event.AbsoluteTime
 	var err error
 
@@ -31,22 +33,28 @@ func writeEvent(writer io.Writer, event *MidiEvent, prevEvent *MidiEvent) error
 	err = writeVarInt(writer, delta, false)
 
 	if err != nil {
-		return nil
+		return 0, err
 	}
 
 	if event.EventType == Metadata {
-		var channelType uint8 = 0xFF
+		var channelType uint8 = 0xF0 | event.Channel
+
+		if event.Channel != MetaChannel && !event.IsSysEx() {
+			return 0, &UnknownEventError{MidiEventType(channelType)}
+		}
 
 		err = binary.Write(writer, binary.BigEndian, &channelType)
 
 		if err != nil {
-			return err
+			return 0, err
 		}
 
-		err = binary.Write(writer, binary.BigEndian, &event.FirstParam)
+		if event.IsMeta() {
+			err = binary.Write(writer, binary.BigEndian, &event.FirstParam)
 
-		if err != nil {
-			return err
+			if err != nil {
+				return 0, err
+			}
 		}
 
 		var dataLen uint32 = uint32(len(event.Metadata))
@@ -54,73 +62,100 @@ func writeEvent(writer io.Writer, event *MidiEvent, prevEvent *MidiEvent) error
 		err = writeVarInt(writer, dataLen, false)
 
 		if err != nil {
-			return err
+			return 0, err
 		}
 
 		_, err = writer.Write(event.Metadata)
 
 		if err != nil {
-			return err
+			return 0, err
 		}
-	} else {
-		if prevEvent == nil ||
-			event.EventType != prevEvent.EventType ||
-			event.Channel != prevEvent.Channel {
-			var channelType uint8 = (uint8(event.EventType) << 4) | event.Channel
 
-			err = binary.Write(writer, binary.BigEndian, &channelType)
+		return 0, nil
+	}
 
-			if err != nil {
-				return err
-			}
-		}
+	byteCount, err := bytesForEvent(event.EventType)
 
-		err = binary.Write(writer, binary.BigEndian, &event.FirstParam)
+	if err != nil {
+		return 0, err
+	}
+
+	var status uint8 = (uint8(event.EventType) << 4) | event.Channel
+
+	if status != runningStatus {
+		err = binary.Write(writer, binary.BigEndian, &status)
 
 		if err != nil {
-			return err
+			return 0, err
 		}
+	}
 
-		if bytesForEvent(event.EventType) == 2 {
-			err = binary.Write(writer, binary.BigEndian, &event.SecondParam)
+	err = binary.Write(writer, binary.BigEndian, &event.FirstParam)
 
-			if err != nil {
-				return err
-			}
+	if err != nil {
+		return 0, err
+	}
+
+	if byteCount == 2 {
+		err = binary.Write(writer, binary.BigEndian, &event.SecondParam)
+
+		if err != nil {
+			return 0, err
 		}
 	}
 
-	return nil
+	return status, nil
 }
 
-func writeTrack(writer io.Writer, track *Track) error {
-	var trackHeader uint32 = TrackHeader
-	var err = binary.Write(writer, binary.BigEndian, &trackHeader)
+func writeChunk(writer io.Writer, chunkType uint32, data []byte) error {
+	var err = binary.Write(writer, binary.BigEndian, &chunkType)
 
 	if err != nil {
 		return err
 	}
 
-	var trackContent bytes.Buffer
-	var prevEvent *MidiEvent = nil
+	var length uint32 = uint32(len(data))
 
-	for _, event := range track.Events {
-		writeEvent(&trackContent, event, prevEvent)
-		prevEvent = event
+	err = binary.Write(writer, binary.BigEndian, &length)
+
+	if err != nil {
+		return err
 	}
 
-	var trackLength uint32 = uint32(trackContent.Len())
+	_, err = writer.Write(data)
 
-	err = binary.Write(writer, binary.BigEndian, &trackLength)
+	return err
+}
 
-	if err != nil {
-		return err
+func writeTrack(writer io.Writer, track *Track, trackIndex int) error {
+	var trackContent bytes.Buffer
+	var prevEvent *MidiEvent = nil
+	var runningStatus uint8 = 0
+	var err error
+
+	for eventIndex, event := range track.Events {
+		var offset = int64(trackContent.Len())
+		runningStatus, err = writeEvent(&trackContent, event, prevEvent, runningStatus)
+
+		if err != nil {
+			return &MidiError{trackIndex, eventIndex, offset, err}
+		}
+
+		prevEvent = event
 	}
 
-	_, err = writer.Write(trackContent.Bytes())
+	return writeChunk(writer, TrackHeader, trackContent.Bytes())
+}
 
-	if err != nil {
-		return err
+func writeUnknownChunks(writer io.Writer, midi *Midi, trackIndex int, isLast bool) error {
+	for _, chunk := range midi.UnknownChunks {
+		if chunk.TrackIndex == trackIndex || (isLast && chunk.TrackIndex > trackIndex) {
+			err := writeChunk(writer, chunk.Type, chunk.Data)
+
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -161,13 +196,19 @@ func WriteMidi(writer io.Writer, midi *Midi) error {
 		return err
 	}
 
-	for _, track := range midi.Tracks {
-		err = writeTrack(writer, track)
+	for trackIndex, track := range midi.Tracks {
+		err = writeUnknownChunks(writer, midi, trackIndex, false)
+
+		if err != nil {
+			return err
+		}
+
+		err = writeTrack(writer, track, trackIndex)
 
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return writeUnknownChunks(writer, midi, len(midi.Tracks), true)
 }
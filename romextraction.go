@@ -2,11 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"github.com/lambertjamesd/sfz2n64/al64"
+	"github.com/lambertjamesd/sfz2n64/audioconvert"
 	"github.com/lambertjamesd/sfz2n64/convert"
 	"github.com/lambertjamesd/sfz2n64/midi"
 	"github.com/lambertjamesd/sfz2n64/romextractor"
@@ -112,7 +114,7 @@ func extractFromRom(input string, output string) {
 	fmt.Println(fmt.Sprintf("Found %d banks", len(finalBanks)))
 }
 
-func extractMidi(input string, output string) {
+func extractMidi(input string, output string, maxVoices int, reportFormat string, remapPrograms string, pcmSettings *audioconvert.PCMConversionSettings) {
 	midFile, err := os.Open(input)
 
 	if err != nil {
@@ -125,20 +127,54 @@ func extractMidi(input string, output string) {
 	inputMidi, err := midi.ReadMidi(midFile)
 
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println(fmt.Sprintf("%s: %s", input, err.Error()))
 		os.Exit(1)
 	}
 
-	bankFile, _, _, err := parseInputBank(output)
+	bankFile, _, _, err := parseInputBank(output, pcmSettings)
 
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	modifiedMidi, maxActiveNotes := convert.SimplifyMidi(inputMidi, bankFile.BankArray[0], 20)
+	if remapPrograms != "" {
+		// keep the json report the only thing written to stdout
+		var remapReport io.Writer = os.Stdout
+
+		if reportFormat == "json" {
+			remapReport = os.Stderr
+		}
+
+		inputMidi, err = remapSequencePrograms(inputMidi, bankFile.BankArray[0], remapPrograms, remapReport)
+
+		if err != nil {
+			fmt.Println(fmt.Sprintf("%s: %s", input, err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	result, err := convert.SimplifyMidi(inputMidi, bankFile.BankArray[0], maxVoices)
+
+	if err != nil {
+		fmt.Println(fmt.Sprintf("%s: %s", input, err.Error()))
+		os.Exit(1)
+	}
+
+	if reportFormat == "json" {
+		err = result.Report.WriteJSON(os.Stdout)
 
-	fmt.Println(fmt.Sprintf("Max number of active notes %d\n", maxActiveNotes))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		result.Report.Write(os.Stdout)
+
+		if len(result.Report.Changes) > 0 {
+			fmt.Println(fmt.Sprintf("Changed %d notes to fit in %d voices", len(result.Report.Changes), maxVoices))
+		}
+	}
 
 	outFile, err := os.OpenFile(input[0:len(input)-4]+"Modified.mid", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0664)
 
@@ -149,7 +185,7 @@ func extractMidi(input string, output string) {
 
 	defer outFile.Close()
 
-	err = midi.WriteMidi(outFile, modifiedMidi)
+	err = midi.WriteMidi(outFile, result.Midi)
 
 	if err != nil {
 		fmt.Println(err)
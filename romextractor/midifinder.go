@@ -17,7 +17,7 @@ func FindMidi(content []byte) []*midi.Midi {
 				i,
 			}
 
-			midiCheck, err := midi.ReadMidi(&reader)
+			midiCheck, err := midi.ReadEmbeddedMidi(&reader)
 
 			if err == nil {
 				fmt.Println(fmt.Sprintf("Found midi at offset %x", i))
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/lambertjamesd/sfz2n64/al64"
+	"github.com/lambertjamesd/sfz2n64/audioconvert"
+	"github.com/lambertjamesd/sfz2n64/convert"
+	"github.com/lambertjamesd/sfz2n64/midi"
+)
+
+func isSequenceFile(ext string) bool {
+	return ext == ".mid" || ext == ".midi" || ext == ".seq" || isCompactSequenceFile(ext)
+}
+
+func isCompactSequenceFile(ext string) bool {
+	return ext == ".cmf" || ext == ".cseq"
+}
+
+// adds the filename to errors from parsing or writing sequences
+func sequenceFileError(filename string, err error) error {
+	return fmt.Errorf("%s: %w", filename, err)
+}
+
+func readSequence(input string, loopMarkers midi.LoopMarkerSettings) (*midi.Midi, error) {
+	var ext = filepath.Ext(input)
+
+	file, err := os.Open(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	if ext == ".seq" {
+		seq, err := al64.ParseALSeq(file)
+
+		if err != nil {
+			return nil, sequenceFileError(input, err)
+		}
+
+		return seq.ToMidi(), nil
+	} else if isCompactSequenceFile(ext) {
+		seq, err := al64.ParseALCSeq(file)
+
+		if err != nil {
+			return nil, sequenceFileError(input, err)
+		}
+
+		return seq.ToMidi(), nil
+	} else if ext == ".mid" || ext == ".midi" {
+		result, err := midi.ReadMidi(file)
+
+		if err != nil {
+			return nil, sequenceFileError(input, err)
+		}
+
+		result, loopCount, err := midi.ConvertLoopMarkers(result, loopMarkers)
+
+		if err != nil {
+			return nil, sequenceFileError(input, err)
+		}
+
+		if loopCount > 0 {
+			fmt.Printf("Converted %d loops from markers in %s\n", loopCount, input)
+		}
+
+		return result, nil
+	} else {
+		return nil, errors.New("Could not handle sequence file type " + input)
+	}
+}
+
+func writeSequence(output string, sequence *midi.Midi) error {
+	var ext = filepath.Ext(output)
+
+	if !isSequenceFile(ext) {
+		return errors.New("Could not write sequence file type " + output)
+	}
+
+	outFile, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0664)
+
+	if err != nil {
+		return err
+	}
+
+	defer outFile.Close()
+
+	if ext == ".seq" {
+		seq, err := al64.ALSeqFromMidi(sequence)
+
+		if err != nil {
+			return sequenceFileError(output, err)
+		}
+
+		err = seq.Serialize(outFile)
+
+		if err != nil {
+			return sequenceFileError(output, err)
+		}
+
+		return nil
+	} else if isCompactSequenceFile(ext) {
+		seq, err := al64.ALCSeqFromMidi(sequence)
+
+		if err != nil {
+			return sequenceFileError(output, err)
+		}
+
+		err = seq.Serialize(outFile)
+
+		if err != nil {
+			return sequenceFileError(output, err)
+		}
+
+		return nil
+	} else {
+		err = midi.WriteMidi(outFile, sequence)
+
+		if err != nil {
+			return sequenceFileError(output, err)
+		}
+
+		return nil
+	}
+}
+
+// remapPrograms is either auto to replace programs that aren't in the
+// bank or the name of a mapping file read by convert.ParseProgramMapping
+func remapSequencePrograms(sequence *midi.Midi, bank *al64.ALBank, remapPrograms string, report io.Writer) (*midi.Midi, error) {
+	var mapping map[int]int = nil
+
+	if remapPrograms != "auto" {
+		parsed, err := convert.ParseProgramMapping(remapPrograms)
+
+		if err != nil {
+			return nil, err
+		}
+
+		mapping = parsed
+	}
+
+	result, remapReport, err := convert.RemapPrograms(sequence, bank, mapping)
+
+	if err != nil {
+		return nil, err
+	}
+
+	remapReport.Write(report)
+
+	return result, nil
+}
+
+// validateSongs checks that every note in each song can be played by the first
+// bank in the bank file and exits with an error if any problems are found
+func validateSongs(inputs []string, bankFilename string, outputRate int, reportFormat string, pcmSettings *audioconvert.PCMConversionSettings) {
+	bankFile, _, _, err := parseInputBank(bankFilename, pcmSettings)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(bankFile.BankArray) == 0 {
+		fmt.Println(fmt.Sprintf("%s has no banks", bankFilename))
+		os.Exit(1)
+	}
+
+	var report = convert.SongValidationReport{
+		SongCount: len(inputs),
+		Issues:    nil,
+	}
+
+	// loop markers don't matter when validating
+	var noLoopMarkers = midi.LoopMarkerSettings{
+		StartMarker:     "",
+		EndMarker:       "",
+		StartController: -1,
+		EndController:   -1,
+	}
+
+	for _, input := range inputs {
+		sequence, err := readSequence(input, noLoopMarkers)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		issues, err := convert.ValidateSong(bankFile.BankArray[0], input, sequence, uint32(outputRate))
+
+		if err != nil {
+			fmt.Println(sequenceFileError(input, err))
+			os.Exit(1)
+		}
+
+		report.Issues = append(report.Issues, issues...)
+	}
+
+	if reportFormat == "json" {
+		err = report.WriteJSON(os.Stdout)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		report.Write(os.Stdout)
+	}
+
+	if len(report.Issues) > 0 {
+		os.Exit(1)
+	}
+}
+
+func convertSequence(input string, output string, loopMarkers midi.LoopMarkerSettings) {
+	sequence, err := readSequence(input, loopMarkers)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	err = writeSequence(output, sequence)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Wrote sequence to %s\n", output)
+}
+
+func buildSequenceBank(output string, inputs []string, compact bool, loopMarkers midi.LoopMarkerSettings) {
+	var seqFile al64.ALSeqFile
+
+	for _, input := range inputs {
+		sequence, err := readSequence(input, loopMarkers)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		seqData, err := al64.ALSeqDataFromMidi(sequence, compact)
+
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Could not convert %s: %s", input, err.Error()))
+			os.Exit(1)
+		}
+
+		seqFile.SeqArray = append(seqFile.SeqArray, seqData)
+	}
+
+	outFile, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0664)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	defer outFile.Close()
+
+	err = seqFile.Serialize(outFile)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Wrote %d sequences to %s\n", len(seqFile.SeqArray), output)
+}
+
+func extractSequenceBank(input string, output string) {
+	var outExt = filepath.Ext(output)
+
+	file, err := os.Open(input)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	defer file.Close()
+
+	seqFile, err := al64.ReadSeqFile(file)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var withoutExt = output[0 : len(output)-len(outExt)]
+
+	for index, seqData := range seqFile.SeqArray {
+		sequence, err := seqData.ToMidi()
+
+		if err != nil {
+			fmt.Println(fmt.Sprintf("Could not read sequence %d: %s", index, err.Error()))
+			os.Exit(1)
+		}
+
+		err = writeSequence(fmt.Sprintf("%s_%d%s", withoutExt, index, outExt), sequence)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Println(fmt.Sprintf("Extracted %d sequences", len(seqFile.SeqArray)))
+}
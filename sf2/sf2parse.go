@@ -0,0 +1,388 @@
+package sf2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type SeekableReader interface {
+	Read(p []byte) (n int, err error)
+	Seek(offset int64, whence int) (ret int64, err error)
+}
+
+type chunkHeader struct {
+	ID   uint32
+	Size uint32
+}
+
+func readChunkHeader(reader io.Reader) (*chunkHeader, error) {
+	var result chunkHeader
+
+	err := binary.Read(reader, binary.BigEndian, &result.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Read(reader, binary.LittleEndian, &result.Size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func readChunkData(reader io.Reader, size uint32) ([]byte, error) {
+	var result = make([]byte, size)
+	_, err := io.ReadFull(reader, result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func parseName(data []byte) string {
+	var end = bytes.IndexByte(data, 0)
+
+	if end == -1 {
+		end = len(data)
+	}
+
+	return strings.TrimSpace(string(data[0:end]))
+}
+
+// calls callback for each chunk inside of a LIST chunk
+func parseList(reader SeekableReader, size uint32, callback func(header *chunkHeader) error) error {
+	var bytesRead uint32 = 4
+
+	for bytesRead+8 <= size {
+		header, err := readChunkHeader(reader)
+
+		if err != nil {
+			return err
+		}
+
+		startPos, err := reader.Seek(0, os.SEEK_CUR)
+
+		if err != nil {
+			return err
+		}
+
+		err = callback(header)
+
+		if err != nil {
+			return err
+		}
+
+		// chunks are padded to an even size
+		var chunkSize = header.Size + (header.Size & 1)
+
+		_, err = reader.Seek(startPos+int64(chunkSize), os.SEEK_SET)
+
+		if err != nil {
+			return err
+		}
+
+		bytesRead = bytesRead + 8 + chunkSize
+	}
+
+	return nil
+}
+
+func parseInfo(reader SeekableReader, size uint32, result *Sf2File) error {
+	return parseList(reader, size, func(header *chunkHeader) error {
+		data, err := readChunkData(reader, header.Size)
+
+		if err != nil {
+			return err
+		}
+
+		if header.ID == IFIL && len(data) >= 4 {
+			result.Version.Major = binary.LittleEndian.Uint16(data[0:])
+			result.Version.Minor = binary.LittleEndian.Uint16(data[2:])
+		} else if header.ID == ISNG {
+			result.SoundEngine = parseName(data)
+		} else if header.ID == INAM {
+			result.Name = parseName(data)
+		}
+
+		return nil
+	})
+}
+
+func parseSampleData(reader SeekableReader, size uint32, result *Sf2File) error {
+	return parseList(reader, size, func(header *chunkHeader) error {
+		if header.ID == SMPL {
+			result.SampleData = make([]int16, header.Size/2)
+			return binary.Read(reader, binary.LittleEndian, result.SampleData)
+		}
+
+		return nil
+	})
+}
+
+func parseGenerators(data []byte) []Generator {
+	var result = make([]Generator, len(data)/GEN_SIZE)
+
+	for index := range result {
+		var record = data[index*GEN_SIZE:]
+		result[index].Operator = GeneratorOperator(binary.LittleEndian.Uint16(record[0:]))
+		result[index].Amount = binary.LittleEndian.Uint16(record[2:])
+	}
+
+	return result
+}
+
+func parseModulators(data []byte) []Modulator {
+	var result = make([]Modulator, len(data)/MOD_SIZE)
+
+	for index := range result {
+		var record = data[index*MOD_SIZE:]
+		result[index].SrcOper = binary.LittleEndian.Uint16(record[0:])
+		result[index].DestOper = binary.LittleEndian.Uint16(record[2:])
+		result[index].Amount = int16(binary.LittleEndian.Uint16(record[4:]))
+		result[index].AmtSrcOper = binary.LittleEndian.Uint16(record[6:])
+		result[index].TransOper = binary.LittleEndian.Uint16(record[8:])
+	}
+
+	return result
+}
+
+// builds the zones for the bags in the range [bagStart, bagEnd)
+// the first zone is returned seperately if it is a global zone
+func parseZones(bags []byte, generators []Generator, modulators []Modulator, bagStart int, bagEnd int, terminal GeneratorOperator) (*Zone, []*Zone, error) {
+	var globalZone *Zone = nil
+	var zones []*Zone = nil
+
+	if bagEnd < bagStart || (bagEnd+1)*BAG_SIZE > len(bags) {
+		return nil, nil, errors.New(fmt.Sprintf("Invalid bag range %d to %d", bagStart, bagEnd))
+	}
+
+	for bagIndex := bagStart; bagIndex < bagEnd; bagIndex = bagIndex + 1 {
+		var genStart = int(binary.LittleEndian.Uint16(bags[bagIndex*BAG_SIZE:]))
+		var modStart = int(binary.LittleEndian.Uint16(bags[bagIndex*BAG_SIZE+2:]))
+		var genEnd = int(binary.LittleEndian.Uint16(bags[(bagIndex+1)*BAG_SIZE:]))
+		var modEnd = int(binary.LittleEndian.Uint16(bags[(bagIndex+1)*BAG_SIZE+2:]))
+
+		if genEnd < genStart || genEnd > len(generators) || modEnd < modStart || modEnd > len(modulators) {
+			return nil, nil, errors.New(fmt.Sprintf("Invalid zone at bag %d", bagIndex))
+		}
+
+		var zone = &Zone{
+			Generators: append([]Generator(nil), generators[genStart:genEnd]...),
+			Modulators: append([]Modulator(nil), modulators[modStart:modEnd]...),
+		}
+
+		var hasTerminal = len(zone.Generators) > 0 && zone.Generators[len(zone.Generators)-1].Operator == terminal
+
+		if hasTerminal {
+			zones = append(zones, zone)
+		} else if bagIndex == bagStart {
+			globalZone = zone
+		}
+		// any other zone without a terminal generator is ignored
+	}
+
+	return globalZone, zones, nil
+}
+
+func parsePresetData(reader SeekableReader, size uint32, result *Sf2File) error {
+	var chunks = make(map[uint32][]byte)
+
+	err := parseList(reader, size, func(header *chunkHeader) error {
+		data, err := readChunkData(reader, header.Size)
+
+		if err != nil {
+			return err
+		}
+
+		chunks[header.ID] = data
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, id := range []uint32{PHDR, PBAG, PMOD, PGEN, INST, IBAG, IMOD, IGEN, SHDR} {
+		_, ok := chunks[id]
+
+		if !ok {
+			return errors.New("sf2 file is missing part of the preset data")
+		}
+	}
+
+	var phdr = chunks[PHDR]
+	var inst = chunks[INST]
+	var shdr = chunks[SHDR]
+
+	// the last record of each list is a terminal record that is not included
+	var sampleCount = len(shdr)/SHDR_SIZE - 1
+
+	for index := 0; index < sampleCount; index = index + 1 {
+		var record = shdr[index*SHDR_SIZE:]
+
+		result.Samples = append(result.Samples, &Sample{
+			Name:            parseName(record[0:NAME_LENGTH]),
+			Start:           binary.LittleEndian.Uint32(record[20:]),
+			End:             binary.LittleEndian.Uint32(record[24:]),
+			StartLoop:       binary.LittleEndian.Uint32(record[28:]),
+			EndLoop:         binary.LittleEndian.Uint32(record[32:]),
+			SampleRate:      binary.LittleEndian.Uint32(record[36:]),
+			OriginalPitch:   record[40],
+			PitchCorrection: int8(record[41]),
+			SampleLink:      binary.LittleEndian.Uint16(record[42:]),
+			SampleType:      SampleType(binary.LittleEndian.Uint16(record[44:])),
+		})
+	}
+
+	var instGenerators = parseGenerators(chunks[IGEN])
+	var instModulators = parseModulators(chunks[IMOD])
+	var instCount = len(inst)/INST_SIZE - 1
+
+	for index := 0; index < instCount; index = index + 1 {
+		var record = inst[index*INST_SIZE:]
+		var nextRecord = inst[(index+1)*INST_SIZE:]
+
+		globalZone, zones, err := parseZones(
+			chunks[IBAG],
+			instGenerators,
+			instModulators,
+			int(binary.LittleEndian.Uint16(record[20:])),
+			int(binary.LittleEndian.Uint16(nextRecord[20:])),
+			GenSampleID,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		for _, zone := range zones {
+			if int(zone.FindGenerator(GenSampleID).Amount) >= sampleCount {
+				return errors.New(fmt.Sprintf("Instrument %s references an invalid sample", parseName(record[0:NAME_LENGTH])))
+			}
+		}
+
+		result.Instruments = append(result.Instruments, &Instrument{
+			Name:       parseName(record[0:NAME_LENGTH]),
+			GlobalZone: globalZone,
+			Zones:      zones,
+		})
+	}
+
+	var presetGenerators = parseGenerators(chunks[PGEN])
+	var presetModulators = parseModulators(chunks[PMOD])
+	var presetCount = len(phdr)/PHDR_SIZE - 1
+
+	for index := 0; index < presetCount; index = index + 1 {
+		var record = phdr[index*PHDR_SIZE:]
+		var nextRecord = phdr[(index+1)*PHDR_SIZE:]
+
+		globalZone, zones, err := parseZones(
+			chunks[PBAG],
+			presetGenerators,
+			presetModulators,
+			int(binary.LittleEndian.Uint16(record[24:])),
+			int(binary.LittleEndian.Uint16(nextRecord[24:])),
+			GenInstrument,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		for _, zone := range zones {
+			if int(zone.FindGenerator(GenInstrument).Amount) >= instCount {
+				return errors.New(fmt.Sprintf("Preset %s references an invalid instrument", parseName(record[0:NAME_LENGTH])))
+			}
+		}
+
+		result.Presets = append(result.Presets, &Preset{
+			Name:       parseName(record[0:NAME_LENGTH]),
+			Preset:     binary.LittleEndian.Uint16(record[20:]),
+			Bank:       binary.LittleEndian.Uint16(record[22:]),
+			Library:    binary.LittleEndian.Uint32(record[26:]),
+			Genre:      binary.LittleEndian.Uint32(record[30:]),
+			Morphology: binary.LittleEndian.Uint32(record[34:]),
+			GlobalZone: globalZone,
+			Zones:      zones,
+		})
+	}
+
+	return nil
+}
+
+func Parse(reader SeekableReader) (*Sf2File, error) {
+	var result Sf2File
+
+	riff, err := readChunkHeader(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if riff.ID != RIFF_HEADER {
+		return nil, errors.New("Invalid sf2 header")
+	}
+
+	var format uint32
+	err = binary.Read(reader, binary.BigEndian, &format)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if format != SFBK_FORMAT {
+		return nil, errors.New("Invalid sf2 format")
+	}
+
+	var hasPresetData = false
+
+	err = parseList(reader, riff.Size, func(header *chunkHeader) error {
+		if header.ID != LIST_HEADER {
+			return nil
+		}
+
+		var listType uint32
+		err := binary.Read(reader, binary.BigEndian, &listType)
+
+		if err != nil {
+			return err
+		}
+
+		if listType == INFO_LIST {
+			return parseInfo(reader, header.Size, &result)
+		} else if listType == SDTA_LIST {
+			return parseSampleData(reader, header.Size, &result)
+		} else if listType == PDTA_LIST {
+			hasPresetData = true
+			return parsePresetData(reader, header.Size, &result)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasPresetData {
+		return nil, errors.New("sf2 file has no preset data")
+	}
+
+	for _, sample := range result.Samples {
+		if sample.SampleType&0x8000 == 0 && (sample.Start > sample.End || int(sample.End) > len(result.SampleData)) {
+			return nil, errors.New(fmt.Sprintf("Sample %s is outside of the sample data", sample.Name))
+		}
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,186 @@
+package sf2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+func writeChunk(out *bytes.Buffer, id uint32, data []byte) {
+	binary.Write(out, binary.BigEndian, &id)
+	var size = uint32(len(data))
+	binary.Write(out, binary.LittleEndian, &size)
+	out.Write(data)
+
+	if len(data)&1 != 0 {
+		out.WriteByte(0)
+	}
+}
+
+func writeList(out *bytes.Buffer, listType uint32, content []byte) {
+	var data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, &listType)
+	data.Write(content)
+	writeChunk(out, LIST_HEADER, data.Bytes())
+}
+
+func writeName(out *bytes.Buffer, name string) {
+	var data = make([]byte, NAME_LENGTH)
+	// the last byte is always a null terminator
+	copy(data[0:NAME_LENGTH-1], name)
+	out.Write(data)
+}
+
+func stringData(value string) []byte {
+	var result = append([]byte(value), 0)
+
+	if len(result)&1 != 0 {
+		result = append(result, 0)
+	}
+
+	return result
+}
+
+func writeZones(globalZone *Zone, zones []*Zone, bags *bytes.Buffer, mods *bytes.Buffer, gens *bytes.Buffer, genCount *int, modCount *int) {
+	var allZones = zones
+
+	if globalZone != nil {
+		allZones = append([]*Zone{globalZone}, zones...)
+	}
+
+	for _, zone := range allZones {
+		var genIndex = uint16(*genCount)
+		var modIndex = uint16(*modCount)
+		binary.Write(bags, binary.LittleEndian, &genIndex)
+		binary.Write(bags, binary.LittleEndian, &modIndex)
+
+		for _, generator := range zone.Generators {
+			binary.Write(gens, binary.LittleEndian, &generator.Operator)
+			binary.Write(gens, binary.LittleEndian, &generator.Amount)
+		}
+
+		for _, modulator := range zone.Modulators {
+			binary.Write(mods, binary.LittleEndian, &modulator)
+		}
+
+		*genCount = *genCount + len(zone.Generators)
+		*modCount = *modCount + len(zone.Modulators)
+	}
+}
+
+func writeTerminalZone(bags *bytes.Buffer, mods *bytes.Buffer, gens *bytes.Buffer, genCount int, modCount int) {
+	var genIndex = uint16(genCount)
+	var modIndex = uint16(modCount)
+	binary.Write(bags, binary.LittleEndian, &genIndex)
+	binary.Write(bags, binary.LittleEndian, &modIndex)
+	mods.Write(make([]byte, MOD_SIZE))
+	gens.Write(make([]byte, GEN_SIZE))
+}
+
+func (file *Sf2File) generatePresetData() []byte {
+	var phdr, pbag, pmod, pgen bytes.Buffer
+	var genCount = 0
+	var modCount = 0
+
+	for _, preset := range file.Presets {
+		writeName(&phdr, preset.Name)
+		binary.Write(&phdr, binary.LittleEndian, &preset.Preset)
+		binary.Write(&phdr, binary.LittleEndian, &preset.Bank)
+		var bagIndex = uint16(pbag.Len() / BAG_SIZE)
+		binary.Write(&phdr, binary.LittleEndian, &bagIndex)
+		binary.Write(&phdr, binary.LittleEndian, &preset.Library)
+		binary.Write(&phdr, binary.LittleEndian, &preset.Genre)
+		binary.Write(&phdr, binary.LittleEndian, &preset.Morphology)
+
+		writeZones(preset.GlobalZone, preset.Zones, &pbag, &pmod, &pgen, &genCount, &modCount)
+	}
+
+	writeName(&phdr, "EOP")
+	phdr.Write(make([]byte, 4))
+	var bagIndex = uint16(pbag.Len() / BAG_SIZE)
+	binary.Write(&phdr, binary.LittleEndian, &bagIndex)
+	phdr.Write(make([]byte, 12))
+	writeTerminalZone(&pbag, &pmod, &pgen, genCount, modCount)
+
+	var inst, ibag, imod, igen bytes.Buffer
+	genCount = 0
+	modCount = 0
+
+	for _, instrument := range file.Instruments {
+		writeName(&inst, instrument.Name)
+		var bagIndex = uint16(ibag.Len() / BAG_SIZE)
+		binary.Write(&inst, binary.LittleEndian, &bagIndex)
+
+		writeZones(instrument.GlobalZone, instrument.Zones, &ibag, &imod, &igen, &genCount, &modCount)
+	}
+
+	writeName(&inst, "EOI")
+	bagIndex = uint16(ibag.Len() / BAG_SIZE)
+	binary.Write(&inst, binary.LittleEndian, &bagIndex)
+	writeTerminalZone(&ibag, &imod, &igen, genCount, modCount)
+
+	var shdr bytes.Buffer
+
+	for _, sample := range file.Samples {
+		writeName(&shdr, sample.Name)
+		binary.Write(&shdr, binary.LittleEndian, &sample.Start)
+		binary.Write(&shdr, binary.LittleEndian, &sample.End)
+		binary.Write(&shdr, binary.LittleEndian, &sample.StartLoop)
+		binary.Write(&shdr, binary.LittleEndian, &sample.EndLoop)
+		binary.Write(&shdr, binary.LittleEndian, &sample.SampleRate)
+		binary.Write(&shdr, binary.LittleEndian, &sample.OriginalPitch)
+		binary.Write(&shdr, binary.LittleEndian, &sample.PitchCorrection)
+		binary.Write(&shdr, binary.LittleEndian, &sample.SampleLink)
+		binary.Write(&shdr, binary.LittleEndian, &sample.SampleType)
+	}
+
+	writeName(&shdr, "EOS")
+	shdr.Write(make([]byte, SHDR_SIZE-NAME_LENGTH))
+
+	var result bytes.Buffer
+	writeChunk(&result, PHDR, phdr.Bytes())
+	writeChunk(&result, PBAG, pbag.Bytes())
+	writeChunk(&result, PMOD, pmod.Bytes())
+	writeChunk(&result, PGEN, pgen.Bytes())
+	writeChunk(&result, INST, inst.Bytes())
+	writeChunk(&result, IBAG, ibag.Bytes())
+	writeChunk(&result, IMOD, imod.Bytes())
+	writeChunk(&result, IGEN, igen.Bytes())
+	writeChunk(&result, SHDR, shdr.Bytes())
+	return result.Bytes()
+}
+
+func (file *Sf2File) Serialize(out io.Writer) error {
+	var info bytes.Buffer
+	var version [2]uint16 = [2]uint16{file.Version.Major, file.Version.Minor}
+	var versionData bytes.Buffer
+	binary.Write(&versionData, binary.LittleEndian, &version)
+	writeChunk(&info, IFIL, versionData.Bytes())
+	writeChunk(&info, ISNG, stringData(file.SoundEngine))
+	writeChunk(&info, INAM, stringData(file.Name))
+
+	var sampleData bytes.Buffer
+	var samples bytes.Buffer
+	binary.Write(&samples, binary.LittleEndian, file.SampleData)
+	writeChunk(&sampleData, SMPL, samples.Bytes())
+
+	var content bytes.Buffer
+	var format uint32 = SFBK_FORMAT
+	binary.Write(&content, binary.BigEndian, &format)
+	writeList(&content, INFO_LIST, info.Bytes())
+	writeList(&content, SDTA_LIST, sampleData.Bytes())
+	writeList(&content, PDTA_LIST, file.generatePresetData())
+
+	var header uint32 = RIFF_HEADER
+	err := binary.Write(out, binary.BigEndian, &header)
+
+	if err != nil {
+		return err
+	}
+
+	var size = uint32(content.Len())
+	binary.Write(out, binary.LittleEndian, &size)
+
+	_, err = out.Write(content.Bytes())
+	return err
+}
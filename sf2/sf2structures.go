@@ -0,0 +1,182 @@
+package sf2
+
+const RIFF_HEADER = 0x52494646
+const LIST_HEADER = 0x4C495354
+const SFBK_FORMAT = 0x7366626B
+
+const INFO_LIST = 0x494E464F
+const SDTA_LIST = 0x73647461
+const PDTA_LIST = 0x70647461
+
+const IFIL = 0x6966696C
+const ISNG = 0x69736E67
+const INAM = 0x494E414D
+const SMPL = 0x736D706C
+const PHDR = 0x70686472
+const PBAG = 0x70626167
+const PMOD = 0x706D6F64
+const PGEN = 0x7067656E
+const INST = 0x696E7374
+const IBAG = 0x69626167
+const IMOD = 0x696D6F64
+const IGEN = 0x6967656E
+const SHDR = 0x73686472
+
+// size of each record in the pdta list
+const (
+	PHDR_SIZE = 38
+	BAG_SIZE  = 4
+	MOD_SIZE  = 10
+	GEN_SIZE  = 4
+	INST_SIZE = 22
+	SHDR_SIZE = 46
+)
+
+const NAME_LENGTH = 20
+
+// bank number used by General MIDI soundfonts for drum kits
+const PERCUSSION_BANK = 128
+
+type GeneratorOperator uint16
+
+const (
+	GenStartAddrsOffset           GeneratorOperator = 0
+	GenEndAddrsOffset             GeneratorOperator = 1
+	GenStartloopAddrsOffset       GeneratorOperator = 2
+	GenEndloopAddrsOffset         GeneratorOperator = 3
+	GenStartAddrsCoarseOffset     GeneratorOperator = 4
+	GenEndAddrsCoarseOffset       GeneratorOperator = 12
+	GenPan                        GeneratorOperator = 17
+	GenDelayVolEnv                GeneratorOperator = 33
+	GenAttackVolEnv               GeneratorOperator = 34
+	GenHoldVolEnv                 GeneratorOperator = 35
+	GenDecayVolEnv                GeneratorOperator = 36
+	GenSustainVolEnv              GeneratorOperator = 37
+	GenReleaseVolEnv              GeneratorOperator = 38
+	GenInstrument                 GeneratorOperator = 41
+	GenKeyRange                   GeneratorOperator = 43
+	GenVelRange                   GeneratorOperator = 44
+	GenStartloopAddrsCoarseOffset GeneratorOperator = 45
+	GenInitialAttenuation         GeneratorOperator = 48
+	GenEndloopAddrsCoarseOffset   GeneratorOperator = 50
+	GenCoarseTune                 GeneratorOperator = 51
+	GenFineTune                   GeneratorOperator = 52
+	GenSampleID                   GeneratorOperator = 53
+	GenSampleModes                GeneratorOperator = 54
+	GenScaleTuning                GeneratorOperator = 56
+	GenOverridingRootKey          GeneratorOperator = 58
+	GenEndOper                    GeneratorOperator = 60
+)
+
+type SampleType uint16
+
+const (
+	MonoSample      SampleType = 1
+	RightSample     SampleType = 2
+	LeftSample      SampleType = 4
+	LinkedSample    SampleType = 8
+	RomMonoSample   SampleType = 0x8001
+	RomRightSample  SampleType = 0x8002
+	RomLeftSample   SampleType = 0x8004
+	RomLinkedSample SampleType = 0x8008
+)
+
+// values of GenSampleModes
+const (
+	LoopNone         = 0
+	LoopContinuous   = 1
+	LoopUntilRelease = 3
+)
+
+type Generator struct {
+	Operator GeneratorOperator
+	Amount   uint16
+}
+
+type Modulator struct {
+	SrcOper    uint16
+	DestOper   uint16
+	Amount     int16
+	AmtSrcOper uint16
+	TransOper  uint16
+}
+
+// Zone is a list of generators and modulators shared by presets and
+// instruments. The last generator of a preset zone is GenInstrument and
+// the last generator of an instrument zone is GenSampleID
+type Zone struct {
+	Generators []Generator
+	Modulators []Modulator
+}
+
+type Preset struct {
+	Name       string
+	Preset     uint16
+	Bank       uint16
+	Library    uint32
+	Genre      uint32
+	Morphology uint32
+	GlobalZone *Zone
+	Zones      []*Zone
+}
+
+type Instrument struct {
+	Name       string
+	GlobalZone *Zone
+	Zones      []*Zone
+}
+
+type Sample struct {
+	Name            string
+	Start           uint32
+	End             uint32
+	StartLoop       uint32
+	EndLoop         uint32
+	SampleRate      uint32
+	OriginalPitch   uint8
+	PitchCorrection int8
+	SampleLink      uint16
+	SampleType      SampleType
+}
+
+type Version struct {
+	Major uint16
+	Minor uint16
+}
+
+type Sf2File struct {
+	Version     Version
+	SoundEngine string
+	Name        string
+	Presets     []*Preset
+	Instruments []*Instrument
+	Samples     []*Sample
+	// 16 bit samples shared by all of the sample headers
+	SampleData []int16
+}
+
+func (generator *Generator) AsInt() int {
+	return int(int16(generator.Amount))
+}
+
+func (generator *Generator) AsRange() (uint8, uint8) {
+	return uint8(generator.Amount), uint8(generator.Amount >> 8)
+}
+
+func RangeAmount(low uint8, high uint8) uint16 {
+	return uint16(low) | (uint16(high) << 8)
+}
+
+func (zone *Zone) FindGenerator(operator GeneratorOperator) *Generator {
+	if zone == nil {
+		return nil
+	}
+
+	for index := range zone.Generators {
+		if zone.Generators[index].Operator == operator {
+			return &zone.Generators[index]
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package wav
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"os"
@@ -11,13 +12,23 @@ type SeekableReader interface {
 	Seek(offset int64, whence int) (ret int64, err error)
 }
 
-func parseHeader(reader SeekableReader, header *WaveHeader) {
+func parseHeader(reader SeekableReader, header *WaveHeader, chunkSize int32) {
 	binary.Read(reader, binary.LittleEndian, &header.Format)
 	binary.Read(reader, binary.LittleEndian, &header.NChannels)
 	binary.Read(reader, binary.LittleEndian, &header.SampleRate)
 	binary.Read(reader, binary.LittleEndian, &header.ByteRate)
 	binary.Read(reader, binary.LittleEndian, &header.BlockAlign)
 	binary.Read(reader, binary.LittleEndian, &header.BitsPerSample)
+
+	if header.Format == FORMAT_EXTENSIBLE && chunkSize >= 40 {
+		var extensionSize uint16
+		var extensible WaveFormatExtensible
+		binary.Read(reader, binary.LittleEndian, &extensionSize)
+		binary.Read(reader, binary.LittleEndian, &extensible.ValidBitsPerSample)
+		binary.Read(reader, binary.LittleEndian, &extensible.ChannelMask)
+		binary.Read(reader, binary.LittleEndian, &extensible.SubFormat)
+		header.Extensible = &extensible
+	}
 }
 
 func parseData(reader SeekableReader, len int32) []byte {
@@ -26,6 +37,98 @@ func parseData(reader SeekableReader, len int32) []byte {
 	return result
 }
 
+func parseSampler(data []byte) *SamplerChunk {
+	var result SamplerChunk
+	var reader = bytes.NewReader(data)
+	var loopCount uint32
+	var samplerDataSize uint32
+
+	binary.Read(reader, binary.LittleEndian, &result.Manufacturer)
+	binary.Read(reader, binary.LittleEndian, &result.Product)
+	binary.Read(reader, binary.LittleEndian, &result.SamplePeriod)
+	binary.Read(reader, binary.LittleEndian, &result.MIDIUnityNote)
+	binary.Read(reader, binary.LittleEndian, &result.MIDIPitchFraction)
+	binary.Read(reader, binary.LittleEndian, &result.SMPTEFormat)
+	binary.Read(reader, binary.LittleEndian, &result.SMPTEOffset)
+	binary.Read(reader, binary.LittleEndian, &loopCount)
+	binary.Read(reader, binary.LittleEndian, &samplerDataSize)
+
+	for i := uint32(0); i < loopCount; i = i + 1 {
+		var loop SampleLoop
+		err := binary.Read(reader, binary.LittleEndian, &loop)
+
+		if err != nil {
+			break
+		}
+
+		result.Loops = append(result.Loops, loop)
+	}
+
+	if samplerDataSize > 0 && int(samplerDataSize) <= reader.Len() {
+		result.SamplerData = make([]byte, samplerDataSize)
+		reader.Read(result.SamplerData)
+	}
+
+	return &result
+}
+
+func parseCues(data []byte) []CuePoint {
+	var result []CuePoint = nil
+	var reader = bytes.NewReader(data)
+	var cueCount uint32
+
+	binary.Read(reader, binary.LittleEndian, &cueCount)
+
+	for i := uint32(0); i < cueCount; i = i + 1 {
+		var cue CuePoint
+		err := binary.Read(reader, binary.LittleEndian, &cue)
+
+		if err != nil {
+			break
+		}
+
+		result = append(result, cue)
+	}
+
+	return result
+}
+
+func parseLabels(data []byte) []Label {
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != ADTL_TYPE {
+		return nil
+	}
+
+	var result []Label = nil
+	var offset = 4
+
+	for offset+8 <= len(data) {
+		var header = binary.BigEndian.Uint32(data[offset:])
+		var chunkSize = int(binary.LittleEndian.Uint32(data[offset+4:]))
+		offset = offset + 8
+
+		if chunkSize > len(data)-offset {
+			break
+		}
+
+		if header == LABEL_HEADER && chunkSize >= 4 {
+			var text = data[offset+4 : offset+chunkSize]
+
+			if nullIndex := bytes.IndexByte(text, 0); nullIndex != -1 {
+				text = text[0:nullIndex]
+			}
+
+			result = append(result, Label{
+				CuePointID: binary.LittleEndian.Uint32(data[offset:]),
+				Text:       string(text),
+			})
+		}
+
+		offset = offset + chunkSize + chunkSize&1
+	}
+
+	return result
+}
+
 func Parse(reader SeekableReader) (*Wave, error) {
 	var result Wave
 
@@ -52,26 +155,45 @@ func Parse(reader SeekableReader) (*Wave, error) {
 	var hasHeader = false
 	var hasData = false
 
-	for !hasHeader || !hasData {
+	for {
 		err = binary.Read(reader, binary.BigEndian, &header)
 
 		if err != nil {
-			return nil, err
+			break
 		}
 
-		binary.Read(reader, binary.LittleEndian, &chunkSize)
+		err = binary.Read(reader, binary.LittleEndian, &chunkSize)
+
+		if err != nil {
+			break
+		}
 
 		startPos, _ := reader.Seek(0, os.SEEK_CUR)
 
 		if header == FORMAT_HEADER {
-			parseHeader(reader, &result.Header)
+			parseHeader(reader, &result.Header, chunkSize)
 			hasHeader = true
 		} else if header == DATA_HEADER {
 			result.Data = parseData(reader, chunkSize)
 			hasData = true
+		} else if header == SAMPLER_HEADER {
+			result.Sampler = parseSampler(parseData(reader, chunkSize))
+		} else if header == CUE_HEADER {
+			result.Cues = parseCues(parseData(reader, chunkSize))
+		} else if header == LIST_HEADER {
+			result.Labels = append(result.Labels, parseLabels(parseData(reader, chunkSize))...)
 		}
 
-		reader.Seek(startPos+int64(chunkSize), os.SEEK_SET)
+		// chunks are padded to an even number of bytes
+		reader.Seek(startPos+int64(chunkSize)+int64(chunkSize&1), os.SEEK_SET)
+	}
+
+	if !hasHeader {
+		return nil, errors.New("wav file is missing fmt chunk")
+	}
+
+	if !hasData {
+		return nil, errors.New("wav file is missing data chunk")
 	}
 
 	return &result, nil
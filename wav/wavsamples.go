@@ -0,0 +1,83 @@
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// BitDepth is the number of meaningful bits in each sample
+func (header *WaveHeader) BitDepth() int {
+	if header.Extensible != nil && header.Extensible.ValidBitsPerSample != 0 {
+		return int(header.Extensible.ValidBitsPerSample)
+	}
+
+	return int(header.BitsPerSample)
+}
+
+// ChannelSamples decodes the sample data into one array per channel
+// with each sample scaled to be in the range -1 to 1
+func (wave *Wave) ChannelSamples() ([][]float64, error) {
+	var header = &wave.Header
+
+	if header.NChannels == 0 {
+		return nil, errors.New("wav file has no channels")
+	}
+
+	var format = header.SampleFormat()
+	var sampleSize = int(header.BitsPerSample+7) / 8
+
+	if header.BlockAlign != 0 && int(header.BlockAlign)/int(header.NChannels) > sampleSize {
+		sampleSize = int(header.BlockAlign) / int(header.NChannels)
+	}
+
+	if format == FORMAT_PCM {
+		if sampleSize < 1 || sampleSize > 4 {
+			return nil, errors.New(fmt.Sprintf("wav file has unsupported sample size of %d bits", header.BitsPerSample))
+		}
+	} else if format == FORMAT_IEEE_FLOAT {
+		if sampleSize != 4 && sampleSize != 8 {
+			return nil, errors.New(fmt.Sprintf("wav file has unsupported float size of %d bits", header.BitsPerSample))
+		}
+	} else {
+		return nil, errors.New(fmt.Sprintf("wav file has unsupported format %d", format))
+	}
+
+	var channelCount = int(header.NChannels)
+	var frameSize = sampleSize * channelCount
+	var frameCount = len(wave.Data) / frameSize
+	var result = make([][]float64, channelCount)
+
+	for channel := range result {
+		result[channel] = make([]float64, frameCount)
+	}
+
+	for frame := 0; frame < frameCount; frame = frame + 1 {
+		for channel := 0; channel < channelCount; channel = channel + 1 {
+			var offset = frame*frameSize + channel*sampleSize
+			var sampleData = wave.Data[offset : offset+sampleSize]
+
+			if format == FORMAT_IEEE_FLOAT {
+				if sampleSize == 4 {
+					result[channel][frame] = float64(math.Float32frombits(binary.LittleEndian.Uint32(sampleData)))
+				} else {
+					result[channel][frame] = math.Float64frombits(binary.LittleEndian.Uint64(sampleData))
+				}
+			} else if sampleSize == 1 {
+				// 8 bit samples are unsigned
+				result[channel][frame] = (float64(sampleData[0]) - 128) / 128
+			} else {
+				var value int32 = 0
+
+				for index := 0; index < sampleSize; index = index + 1 {
+					value = value | int32(sampleData[index])<<(8*(4-sampleSize+index))
+				}
+
+				result[channel][frame] = float64(value) / (1 << 31)
+			}
+		}
+	}
+
+	return result, nil
+}
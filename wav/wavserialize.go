@@ -16,14 +16,111 @@ func generateHeader(header *WaveHeader) []byte {
 	binary.Write(&result, binary.LittleEndian, &header.BlockAlign)
 	binary.Write(&result, binary.LittleEndian, &header.BitsPerSample)
 
+	if header.Format == FORMAT_EXTENSIBLE && header.Extensible != nil {
+		var extensionSize uint16 = 22
+		binary.Write(&result, binary.LittleEndian, &extensionSize)
+		binary.Write(&result, binary.LittleEndian, &header.Extensible.ValidBitsPerSample)
+		binary.Write(&result, binary.LittleEndian, &header.Extensible.ChannelMask)
+		binary.Write(&result, binary.LittleEndian, &header.Extensible.SubFormat)
+	}
+
+	return result.Bytes()
+}
+
+func generateSampler(sampler *SamplerChunk) []byte {
+	var result bytes.Buffer
+
+	binary.Write(&result, binary.LittleEndian, &sampler.Manufacturer)
+	binary.Write(&result, binary.LittleEndian, &sampler.Product)
+	binary.Write(&result, binary.LittleEndian, &sampler.SamplePeriod)
+	binary.Write(&result, binary.LittleEndian, &sampler.MIDIUnityNote)
+	binary.Write(&result, binary.LittleEndian, &sampler.MIDIPitchFraction)
+	binary.Write(&result, binary.LittleEndian, &sampler.SMPTEFormat)
+	binary.Write(&result, binary.LittleEndian, &sampler.SMPTEOffset)
+
+	var loopCount = uint32(len(sampler.Loops))
+	binary.Write(&result, binary.LittleEndian, &loopCount)
+	var samplerDataSize = uint32(len(sampler.SamplerData))
+	binary.Write(&result, binary.LittleEndian, &samplerDataSize)
+
+	for _, loop := range sampler.Loops {
+		binary.Write(&result, binary.LittleEndian, &loop)
+	}
+
+	result.Write(sampler.SamplerData)
+
+	return result.Bytes()
+}
+
+func generateCues(cues []CuePoint) []byte {
+	var result bytes.Buffer
+
+	var cueCount = uint32(len(cues))
+	binary.Write(&result, binary.LittleEndian, &cueCount)
+
+	for _, cue := range cues {
+		binary.Write(&result, binary.LittleEndian, &cue)
+	}
+
+	return result.Bytes()
+}
+
+func generateLabels(labels []Label) []byte {
+	var result bytes.Buffer
+
+	var headStore uint32 = ADTL_TYPE
+	binary.Write(&result, binary.BigEndian, &headStore)
+
+	for _, label := range labels {
+		var text = append([]byte(label.Text), 0)
+
+		headStore = LABEL_HEADER
+		binary.Write(&result, binary.BigEndian, &headStore)
+		var chunkSize = uint32(4 + len(text))
+		binary.Write(&result, binary.LittleEndian, &chunkSize)
+		binary.Write(&result, binary.LittleEndian, &label.CuePointID)
+		result.Write(text)
+
+		if chunkSize&1 != 0 {
+			result.WriteByte(0)
+		}
+	}
+
 	return result.Bytes()
 }
 
+func writeChunk(out *bytes.Buffer, header uint32, data []byte) {
+	binary.Write(out, binary.BigEndian, &header)
+	var chunkSize = uint32(len(data))
+	binary.Write(out, binary.LittleEndian, &chunkSize)
+	out.Write(data)
+
+	// chunks are padded to an even number of bytes
+	if chunkSize&1 != 0 {
+		out.WriteByte(0)
+	}
+}
+
 func (wave *Wave) Serialize(out io.Writer) error {
-	var header = generateHeader(&wave.Header)
+	var body bytes.Buffer
 
-	var headStore uint32
-	var chunkSize uint32
+	var headStore uint32 = WAVE_FORMAT
+	binary.Write(&body, binary.BigEndian, &headStore)
+
+	writeChunk(&body, FORMAT_HEADER, generateHeader(&wave.Header))
+	writeChunk(&body, DATA_HEADER, wave.Data)
+
+	if wave.Sampler != nil {
+		writeChunk(&body, SAMPLER_HEADER, generateSampler(wave.Sampler))
+	}
+
+	if len(wave.Cues) > 0 {
+		writeChunk(&body, CUE_HEADER, generateCues(wave.Cues))
+	}
+
+	if len(wave.Labels) > 0 {
+		writeChunk(&body, LIST_HEADER, generateLabels(wave.Labels))
+	}
 
 	headStore = RIFF_HEADER
 	err := binary.Write(out, binary.BigEndian, &headStore)
@@ -32,22 +129,9 @@ func (wave *Wave) Serialize(out io.Writer) error {
 		return err
 	}
 
-	chunkSize = uint32(len(header) + len(wave.Data) + 20)
+	var chunkSize = uint32(body.Len())
 	binary.Write(out, binary.LittleEndian, &chunkSize)
 
-	headStore = WAVE_FORMAT
-	binary.Write(out, binary.BigEndian, &headStore)
-
-	headStore = FORMAT_HEADER
-	binary.Write(out, binary.BigEndian, &headStore)
-	chunkSize = uint32(len(header))
-	binary.Write(out, binary.LittleEndian, &chunkSize)
-	out.Write(header)
-
-	headStore = DATA_HEADER
-	binary.Write(out, binary.BigEndian, &headStore)
-	chunkSize = uint32(len(wave.Data))
-	binary.Write(out, binary.LittleEndian, &chunkSize)
-	_, err = out.Write(wave.Data)
+	_, err = out.Write(body.Bytes())
 	return err
 }
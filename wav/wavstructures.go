@@ -1,13 +1,33 @@
 package wav
 
 const (
-	FORMAT_PCM = 1
+	FORMAT_PCM        = 1
+	FORMAT_IEEE_FLOAT = 3
+	FORMAT_EXTENSIBLE = 0xFFFE
 )
 
 const RIFF_HEADER = 0x52494646
 const FORMAT_HEADER = 0x666d7420
 const DATA_HEADER = 0x64617461
 const WAVE_FORMAT = 0x57415645
+const SAMPLER_HEADER = 0x736d706c
+const CUE_HEADER = 0x63756520
+const LIST_HEADER = 0x4c495354
+const ADTL_TYPE = 0x6164746c
+const LABEL_HEADER = 0x6c61626c
+
+const (
+	LOOP_FORWARD     = 0
+	LOOP_ALTERNATING = 1
+	LOOP_BACKWARD    = 2
+)
+
+// the extra fields used by WAVE_FORMAT_EXTENSIBLE
+type WaveFormatExtensible struct {
+	ValidBitsPerSample uint16
+	ChannelMask        uint32
+	SubFormat          [16]byte
+}
 
 type WaveHeader struct {
 	Format        uint16
@@ -16,9 +36,82 @@ type WaveHeader struct {
 	ByteRate      uint32
 	BlockAlign    uint16
 	BitsPerSample uint16
+	Extensible    *WaveFormatExtensible
+}
+
+// SampleFormat returns the format of the samples, looking
+// through the sub format of an extensible header
+func (header *WaveHeader) SampleFormat() uint16 {
+	if header.Format == FORMAT_EXTENSIBLE && header.Extensible != nil {
+		return uint16(header.Extensible.SubFormat[0]) | uint16(header.Extensible.SubFormat[1])<<8
+	}
+
+	return header.Format
+}
+
+// End is the last sample played in the loop. A PlayCount
+// of 0 loops forever
+type SampleLoop struct {
+	CuePointID uint32
+	Type       uint32
+	Start      uint32
+	End        uint32
+	Fraction   uint32
+	PlayCount  uint32
+}
+
+// MIDIPitchFraction is the fraction of a semitone
+// above MIDIUnityNote the sample was recorded at
+type SamplerChunk struct {
+	Manufacturer      uint32
+	Product           uint32
+	SamplePeriod      uint32
+	MIDIUnityNote     uint32
+	MIDIPitchFraction uint32
+	SMPTEFormat       uint32
+	SMPTEOffset       uint32
+	Loops             []SampleLoop
+	SamplerData       []byte
+}
+
+type CuePoint struct {
+	ID           uint32
+	Position     uint32
+	DataChunkID  uint32
+	ChunkStart   uint32
+	BlockStart   uint32
+	SampleOffset uint32
+}
+
+type Label struct {
+	CuePointID uint32
+	Text       string
 }
 
 type Wave struct {
-	Header WaveHeader
-	Data   []byte
+	Header  WaveHeader
+	Data    []byte
+	Sampler *SamplerChunk
+	Cues    []CuePoint
+	Labels  []Label
+}
+
+func (wave *Wave) FindCuePoint(id uint32) *CuePoint {
+	for index := range wave.Cues {
+		if wave.Cues[index].ID == id {
+			return &wave.Cues[index]
+		}
+	}
+
+	return nil
+}
+
+func (wave *Wave) FindLabel(cuePointID uint32) string {
+	for _, label := range wave.Labels {
+		if label.CuePointID == cuePointID {
+			return label.Text
+		}
+	}
+
+	return ""
 }